@@ -1,6 +1,6 @@
 import { EventEmitter } from 'events'
 import { applyReducer, Operation } from 'fast-json-patch'
-import { camelCase, omit } from 'lodash'
+import { camelCase, isPlainObject, mapValues, omit } from 'lodash'
 import { FORMAT_TEXT_MAP, SpanContext, Tracer } from 'opentracing'
 import { Observable, Subscription, Symbol } from 'rxjs'
 import { inspect } from 'util'
@@ -19,7 +19,15 @@ import {
     ResponseMessage,
 } from 'vscode-jsonrpc/lib/messages'
 import { Logger, NoopLogger } from './logging'
-import { InitializeParams, PartialResultParams } from './request-type'
+import {
+    InitializeParams,
+    PartialResultParams,
+    ProgressParams,
+    ProgressToken,
+    WorkDoneProgressBegin,
+    WorkDoneProgressEnd,
+    WorkDoneProgressParams,
+} from './request-type'
 import { TypeScriptService } from './typescript-service'
 
 /**
@@ -41,6 +49,14 @@ function hasMeta(candidate: any): candidate is HasMeta {
     )
 }
 
+/**
+ * Returns the work done token the client passed in the params of a request, if any
+ */
+function getWorkDoneToken(params?: WorkDoneProgressParams | null): ProgressToken | undefined {
+    const token = params && params.workDoneToken
+    return typeof token === 'string' || typeof token === 'number' ? token : undefined
+}
+
 /**
  * Returns true if the passed argument is an object with a `.then()` method
  */
@@ -55,12 +71,38 @@ function isObservable(candidate: any): candidate is Observable<any> {
     return typeof candidate === 'object' && candidate !== null && typeof candidate[Symbol.observable] === 'function'
 }
 
+/**
+ * Returns a copy of the passed value in which all matches of the passed patterns in strings are replaced.
+ * Used to keep secrets like tokens or API keys out of logged messages and spans.
+ *
+ * @param value A JSON value, e.g. a message or its params
+ * @param patterns Patterns to redact, should have the global flag set to replace all matches
+ */
+export function redact<T>(value: T, patterns: RegExp[] = []): T {
+    if (patterns.length === 0) {
+        return value
+    }
+    if (typeof value === 'string') {
+        return patterns.reduce((str: string, pattern) => str.replace(pattern, '[REDACTED]'), value) as any
+    }
+    if (Array.isArray(value)) {
+        return value.map(item => redact(item, patterns)) as any
+    }
+    if (isPlainObject(value)) {
+        return mapValues(value as any, item => redact(item, patterns)) as any
+    }
+    return value
+}
+
 export interface MessageLogOptions {
     /** Logger to use */
     logger?: Logger
 
     /** Whether to log all messages */
     logMessages?: boolean
+
+    /** Patterns of secrets to redact from logged messages */
+    redactPatterns?: RegExp[]
 }
 
 /**
@@ -86,7 +128,7 @@ export class MessageEmitter extends EventEmitter {
         if (options.logMessages && options.logger) {
             const logger = options.logger
             this.on('message', message => {
-                logger.log('-->', message)
+                logger.log('-->', redact(message, options.redactPatterns))
             })
         }
     }
@@ -122,6 +164,7 @@ export class MessageEmitter extends EventEmitter {
 export class MessageWriter {
     private logger: Logger
     private logMessages: boolean
+    private redactPatterns: RegExp[]
     private vscodeWriter: VSCodeStreamMessageWriter
 
     /**
@@ -132,6 +175,7 @@ export class MessageWriter {
         this.vscodeWriter = new VSCodeStreamMessageWriter(output)
         this.logger = options.logger || new NoopLogger()
         this.logMessages = !!options.logMessages
+        this.redactPatterns = options.redactPatterns || []
     }
 
     /**
@@ -142,7 +186,7 @@ export class MessageWriter {
      */
     public write(message: RequestMessage | NotificationMessage | ResponseMessage): void {
         if (this.logMessages) {
-            this.logger.log('<--', message)
+            this.logger.log('<--', redact(message, this.redactPatterns))
         }
         this.vscodeWriter.write(message)
     }
@@ -153,6 +197,12 @@ export interface RegisterLanguageHandlerOptions {
 
     /** An opentracing-compatible tracer */
     tracer?: Tracer
+
+    /** Requests that take longer than this amount of milliseconds to handle are logged as warnings */
+    slowRequestThreshold?: number
+
+    /** Patterns of secrets to redact from params in spans and logged warnings and errors */
+    redactPatterns?: RegExp[]
 }
 
 /**
@@ -170,6 +220,7 @@ export function registerLanguageHandler(
 ): void {
     const logger = options.logger || new NoopLogger()
     const tracer = options.tracer || new Tracer()
+    const redactPatterns = options.redactPatterns || []
 
     /** Tracks Subscriptions for results to unsubscribe them on $/cancelRequest */
     const subscriptions = new Map<string | number, Subscription>()
@@ -181,9 +232,27 @@ export function registerLanguageHandler(
      */
     let initialized = false
 
+    /**
+     * Whether the client requested `shutdown`.
+     * After that, all requests are rejected and only the exit notification is accepted.
+     */
+    let shutDown = false
+
     /** Whether the client supports streaming with $/partialResult */
     let streaming = false
 
+    /**
+     * Answers a request that is not allowed in the current lifecycle state with an error.
+     * Notifications can't be answered and are dropped.
+     */
+    const rejectMessage = (message: RequestMessage | NotificationMessage, code: number, errorMessage: string) => {
+        if (isRequestMessage(message)) {
+            messageWriter.write({ jsonrpc: '2.0', id: message.id, error: { code, message: errorMessage } })
+        } else {
+            logger.warn(`Dropping ${message.method} notification: ${errorMessage}`)
+        }
+    }
+
     messageEmitter.on('message', async message => {
         // Ignore responses
         if (isResponseMessage(message)) {
@@ -193,6 +262,22 @@ export function registerLanguageHandler(
             logger.error('Received invalid message:', message)
             return
         }
+        // Enforce the lifecycle: initialize has to be the first request and may only be sent once,
+        // and nothing but exit is accepted after shutdown
+        if (message.method !== 'exit') {
+            if (shutDown) {
+                rejectMessage(message, ErrorCodes.InvalidRequest, 'Server is shut down')
+                return
+            }
+            if (!initialized && message.method !== 'initialize') {
+                rejectMessage(message, ErrorCodes.ServerNotInitialized, 'Server not initialized')
+                return
+            }
+            if (initialized && message.method === 'initialize') {
+                rejectMessage(message, ErrorCodes.InvalidRequest, 'Server is already initialized')
+                return
+            }
+        }
         switch (message.method) {
             case 'initialize':
                 initialized = true
@@ -200,6 +285,7 @@ export function registerLanguageHandler(
                 break
             case 'shutdown':
                 initialized = false
+                shutDown = true
                 break
             case 'exit':
                 // Ignore exit notification, it's not the responsibility of the TypeScriptService to handle it,
@@ -234,7 +320,11 @@ export function registerLanguageHandler(
             context = tracer.extract(FORMAT_TEXT_MAP, message.meta) || undefined
         }
         const span = tracer.startSpan('Handle ' + message.method, { childOf: context })
-        span.setTag('params', inspect(message.params))
+        span.setTag('method', message.method)
+        if (isRequestMessage(message)) {
+            span.setTag('id', message.id)
+        }
+        span.setTag('params', inspect(redact(message.params, redactPatterns)))
         if (typeof (handler as any)[method] !== 'function') {
             // Method not implemented
             if (isRequestMessage(message)) {
@@ -249,8 +339,11 @@ export function registerLanguageHandler(
             } else {
                 logger.warn(`Method ${method} not implemented`)
             }
+            span.setTag('error', true)
+            span.finish()
             return
         }
+        const startTime = Date.now()
         // Call handler method with params and span
         let observable: Observable<Operation>
         try {
@@ -267,6 +360,30 @@ export function registerLanguageHandler(
             observable = Observable.throw(err)
         }
         if (isRequestMessage(message)) {
+            // If the client passed a work done token, report the start and end of handling the request on it
+            const workDoneToken = getWorkDoneToken(message.params)
+            let workDoneEnded = false
+            const endWorkDone = () => {
+                if (workDoneToken === undefined || workDoneEnded) {
+                    return
+                }
+                workDoneEnded = true
+                messageWriter.write({
+                    jsonrpc: '2.0',
+                    method: '$/progress',
+                    params: { token: workDoneToken, value: { kind: 'end' } } as ProgressParams<WorkDoneProgressEnd>,
+                })
+            }
+            if (workDoneToken !== undefined) {
+                messageWriter.write({
+                    jsonrpc: '2.0',
+                    method: '$/progress',
+                    params: {
+                        token: workDoneToken,
+                        value: { kind: 'begin', title: message.method },
+                    } as ProgressParams<WorkDoneProgressBegin>,
+                })
+            }
             const subscription = observable
                 .do(patch => {
                     if (streaming) {
@@ -286,8 +403,17 @@ export function registerLanguageHandler(
                 // TODO send null if client declared streaming capability
                 .reduce<Operation, any>(applyReducer, null)
                 .finally(() => {
+                    // End progress in case the request was cancelled
+                    endWorkDone()
                     // Finish span
                     span.finish()
+                    const duration = Date.now() - startTime
+                    if (options.slowRequestThreshold !== undefined && duration >= options.slowRequestThreshold) {
+                        logger.warn(
+                            `Slow request ${message.method} (${message.id}) took ${duration}ms, params:`,
+                            inspect(redact(message.params, redactPatterns), { depth: 1 })
+                        )
+                    }
                     // Delete subscription from Map
                     // Make sure to not run this before subscription.set() was called
                     // (in case the Observable is synchronous)
@@ -297,6 +423,8 @@ export function registerLanguageHandler(
                 })
                 .subscribe(
                     result => {
+                        // The end of the progress has to be reported before the response
+                        endWorkDone()
                         // Send final result
                         messageWriter.write({
                             jsonrpc: '2.0',
@@ -309,7 +437,13 @@ export function registerLanguageHandler(
                         span.setTag('error', true)
                         span.log({ event: 'error', 'error.object': err, message: err.message, stack: err.stack })
                         // Log error
-                        logger.error(`Handler for ${message.method} failed:`, err, '\nMessage:', message)
+                        logger.error(
+                            `Handler for ${message.method} failed:`,
+                            err,
+                            '\nMessage:',
+                            redact(message, redactPatterns)
+                        )
+                        endWorkDone()
                         // Send error response
                         messageWriter.write({
                             jsonrpc: '2.0',
@@ -326,9 +460,15 @@ export function registerLanguageHandler(
             subscriptions.set(message.id, subscription)
         } else {
             // For notifications, still subscribe and log potential error
-            observable.subscribe(undefined, err => {
-                logger.error(`Handle ${method}:`, err)
-            })
+            observable
+                .finally(() => {
+                    span.finish()
+                })
+                .subscribe(undefined, err => {
+                    span.setTag('error', true)
+                    span.log({ event: 'error', 'error.object': err, message: err.message, stack: err.stack })
+                    logger.error(`Handle ${method}:`, err)
+                })
         }
     })
 
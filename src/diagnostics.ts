@@ -1,25 +1,67 @@
 import * as ts from 'typescript'
-import { Diagnostic, DiagnosticSeverity, Range } from 'vscode-languageserver'
+import { DiagnosticSeverity, Range } from 'vscode-languageserver'
+import { Diagnostic, DiagnosticRelatedInformation, DiagnosticTag, PositionEncodingKind } from './request-type'
+import { locationUri } from './symbols'
+import { offsetToPosition } from './util'
 
 /**
  * Converts a TypeScript Diagnostic to an LSP Diagnostic
+ *
+ * @param encoding The encoding to count the characters of ranges in
+ * @param relatedInformationSupport Whether the client accepts related information, e.g. "declared here" locations
  */
-export function convertTsDiagnostic(diagnostic: ts.Diagnostic): Diagnostic {
+export function convertTsDiagnostic(
+    diagnostic: ts.Diagnostic,
+    encoding: PositionEncodingKind = 'utf-16',
+    relatedInformationSupport = false
+): Diagnostic {
     const text = ts.flattenDiagnosticMessageText(diagnostic.messageText, '\n')
-    let range: Range = { start: { character: 0, line: 0 }, end: { character: 0, line: 0 } }
-    if (diagnostic.file && diagnostic.start && diagnostic.length) {
-        range = {
-            start: diagnostic.file.getLineAndCharacterOfPosition(diagnostic.start),
-            end: diagnostic.file.getLineAndCharacterOfPosition(diagnostic.start + diagnostic.length),
-        }
-    }
-    return {
-        range,
+    const result: Diagnostic = {
+        range: convertDiagnosticRange(diagnostic, encoding),
         message: text,
         severity: convertDiagnosticCategory(diagnostic.category),
         code: diagnostic.code,
         source: diagnostic.source || 'ts',
     }
+    if (diagnostic.reportsUnnecessary) {
+        result.tags = [DiagnosticTag.Unnecessary]
+    }
+    if (relatedInformationSupport && diagnostic.relatedInformation && diagnostic.relatedInformation.length > 0) {
+        result.relatedInformation = diagnostic.relatedInformation
+            .filter(related => !!related.file)
+            .map(related => convertRelatedInformation(related, encoding))
+    }
+    return result
+}
+
+/**
+ * Converts a TypeScript DiagnosticRelatedInformation (e.g. a "declared here" message) to an LSP DiagnosticRelatedInformation.
+ * Expects the related information to have a file.
+ */
+function convertRelatedInformation(
+    related: ts.DiagnosticRelatedInformation,
+    encoding: PositionEncodingKind
+): DiagnosticRelatedInformation {
+    return {
+        location: {
+            uri: locationUri(related.file!.fileName),
+            range: convertDiagnosticRange(related, encoding),
+        },
+        message: ts.flattenDiagnosticMessageText(related.messageText, '\n'),
+    }
+}
+
+/**
+ * Returns the Range a diagnostic spans, or an empty Range at the start of the file if it has no location
+ */
+function convertDiagnosticRange(diagnostic: ts.DiagnosticRelatedInformation, encoding: PositionEncodingKind): Range {
+    if (!diagnostic.file || diagnostic.start === undefined || diagnostic.length === undefined) {
+        return { start: { character: 0, line: 0 }, end: { character: 0, line: 0 } }
+    }
+    return {
+        start: offsetToPosition(diagnostic.file, diagnostic.start, encoding),
+        end: offsetToPosition(diagnostic.file, diagnostic.start + diagnostic.length, encoding),
+    }
 }
 
 /**
@@ -37,5 +79,8 @@ function convertDiagnosticCategory(category: ts.DiagnosticCategory): DiagnosticS
             return DiagnosticSeverity.Information
         case ts.DiagnosticCategory.Suggestion:
             return DiagnosticSeverity.Hint
+        default:
+            // Be lenient towards categories added in newer TypeScript versions
+            return DiagnosticSeverity.Error
     }
 }
@@ -1,6 +1,7 @@
 import { Glob } from 'glob'
 import * as fs from 'mz/fs'
 import { Span } from 'opentracing'
+import * as path from 'path'
 import { Observable } from 'rxjs'
 import Semaphore from 'semaphore-async-await'
 import { LanguageClient } from './lang-handler'
@@ -65,10 +66,28 @@ export class LocalFileSystem implements FileSystem {
         return uri2path(uri)
     }
 
+    /**
+     * Returns true if the URI points to the root directory or a location inside it.
+     * Guards against listing arbitrary directories through `..` segments or URIs outside of the workspace.
+     */
+    protected isInRoot(uri: string): boolean {
+        let rootPath = path.resolve(this.resolveUriToPath(this.rootUri))
+        let filePath = path.resolve(this.resolveUriToPath(uri))
+        // Windows paths are case-insensitive, e.g. the drive letter may be c: or C:
+        if (path.sep === '\\') {
+            rootPath = rootPath.toLowerCase()
+            filePath = filePath.toLowerCase()
+        }
+        return filePath === rootPath || filePath.startsWith(rootPath.replace(/[\\/]$/, '') + path.sep)
+    }
+
     public getWorkspaceFiles(base = this.rootUri): Observable<string> {
         if (!base.endsWith('/')) {
             base += '/'
         }
+        if (!this.isInRoot(base)) {
+            return Observable.throw(new Error(`${base} is outside of the workspace root ${this.rootUri}`))
+        }
         const cwd = this.resolveUriToPath(base)
         return new Observable<string>(subscriber => {
             const globber = new Glob('*', {
@@ -99,6 +118,7 @@ export class LocalFileSystem implements FileSystem {
     }
 
     public getTextDocumentContent(uri: string): Observable<string> {
+        // Files outside of the root are not refused, they may be referenced through imports or tsconfig extends
         const filePath = this.resolveUriToPath(uri)
         return Observable.fromPromise(fs.readFile(filePath, 'utf8'))
     }
@@ -11,14 +11,23 @@ import {
 import {
     ApplyWorkspaceEditParams,
     ApplyWorkspaceEditResponse,
+    ConfigurationParams,
     LogMessageParams,
     PublishDiagnosticsParams,
+    RegistrationParams,
     TextDocumentIdentifier,
     TextDocumentItem,
 } from 'vscode-languageserver'
 import { HasMeta } from './connection'
 import { MessageEmitter, MessageWriter } from './connection'
-import { CacheGetParams, CacheSetParams, TextDocumentContentParams, WorkspaceFilesParams } from './request-type'
+import {
+    CacheGetParams,
+    CacheSetParams,
+    ProgressParams,
+    TextDocumentContentParams,
+    WorkDoneProgressCreateParams,
+    WorkspaceFilesParams,
+} from './request-type'
 import { traceObservable } from './tracing'
 
 export interface LanguageClient {
@@ -67,6 +76,33 @@ export interface LanguageClient {
      * @param params The edits to apply to the workspace
      */
     workspaceApplyEdit(params: ApplyWorkspaceEditParams, childOf?: Span): Observable<ApplyWorkspaceEditResponse>
+
+    /**
+     * The client/registerCapability request is sent from the server to the client to register for a new
+     * capability on the client side, e.g. to be notified about changes of watched files.
+     * @param params The registrations to make
+     */
+    clientRegisterCapability(params: RegistrationParams, childOf?: Span): Observable<void>
+
+    /**
+     * The workspace/configuration request is sent from the server to the client to fetch configuration
+     * settings from the client. The result contains one settings value per requested item.
+     * @param params The configuration items to fetch
+     */
+    workspaceConfiguration(params: ConfigurationParams, childOf?: Span): Observable<any[]>
+
+    /**
+     * The window/workDoneProgress/create request is sent from the server to the client to ask the
+     * client to create a work done progress token the server can report progress on.
+     * @param params The token to create
+     */
+    windowWorkDoneProgressCreate(params: WorkDoneProgressCreateParams, childOf?: Span): Observable<void>
+
+    /**
+     * The progress notification is sent from the server to the client to report progress for a token
+     * @param params The token and the progress to report
+     */
+    progress(params: ProgressParams): void
 }
 
 /**
@@ -205,4 +241,43 @@ export class RemoteLanguageClient {
     ): Observable<ApplyWorkspaceEditResponse> {
         return this.request('workspace/applyEdit', params, childOf)
     }
+
+    /**
+     * The client/registerCapability request is sent from the server to the client to register for a
+     * new capability on the client side.
+     *
+     * @param params The registrations to make.
+     */
+    public clientRegisterCapability(params: RegistrationParams, childOf = new Span()): Observable<void> {
+        return this.request('client/registerCapability', params, childOf)
+    }
+
+    /**
+     * The workspace/configuration request is sent from the server to the client to fetch configuration
+     * settings from the client.
+     *
+     * @param params The configuration items to fetch.
+     */
+    public workspaceConfiguration(params: ConfigurationParams, childOf = new Span()): Observable<any[]> {
+        return this.request('workspace/configuration', params, childOf)
+    }
+
+    /**
+     * The window/workDoneProgress/create request is sent from the server to the client to ask the
+     * client to create a work done progress token.
+     *
+     * @param params The token to create.
+     */
+    public windowWorkDoneProgressCreate(params: WorkDoneProgressCreateParams, childOf = new Span()): Observable<void> {
+        return this.request('window/workDoneProgress/create', params, childOf)
+    }
+
+    /**
+     * The progress notification is sent from the server to the client to report progress for a token
+     *
+     * @param params The token and the progress to report.
+     */
+    public progress(params: ProgressParams): void {
+        this.notify('$/progress', params)
+    }
 }
@@ -1,7 +1,7 @@
 #!/usr/bin/env node
 
 import { Tracer } from 'opentracing'
-import { isNotificationMessage } from 'vscode-jsonrpc/lib/messages'
+import { isNotificationMessage, isRequestMessage } from 'vscode-jsonrpc/lib/messages'
 import {
     MessageEmitter,
     MessageLogOptions,
@@ -10,7 +10,8 @@ import {
     RegisterLanguageHandlerOptions,
 } from './connection'
 import { RemoteLanguageClient } from './lang-handler'
-import { FileLogger, StderrLogger } from './logging'
+import { FileLogger, isLogLevel, LevelLogger, StderrLogger } from './logging'
+import { TERMINATION_SIGNALS } from './server'
 import { TypeScriptService, TypeScriptServiceOptions } from './typescript-service'
 
 const packageJson = require('../package.json')
@@ -23,9 +24,22 @@ program
     .option('-t, --trace', 'print all requests and responses')
     .option('-l, --logfile [file]', 'log to this file')
     .option('-j, --enable-jaeger', 'enable OpenTracing through Jaeger')
+    .option('--slow-request-threshold [ms]', 'log requests that take longer than this amount of milliseconds', parseInt)
+    .option('--log-level <level>', 'only log messages of this level or above (debug, info, warn, error)', 'debug')
+    .option(
+        '--redact <regexp>',
+        'redact secrets matching this regular expression from traced messages and spans (repeatable)',
+        (pattern: string, patterns: RegExp[]) => [...patterns, new RegExp(pattern, 'g')],
+        []
+    )
     .parse(process.argv)
 
-const logger = program.logfile ? new FileLogger(program.logfile) : new StderrLogger()
+if (!isLogLevel(program.logLevel)) {
+    process.stderr.write(`Invalid log level ${program.logLevel}, expected one of debug, info, warn, error\n`)
+    process.exit(1)
+}
+
+const logger = new LevelLogger(program.logfile ? new FileLogger(program.logfile) : new StderrLogger(), program.logLevel)
 const tracer = program.enableJaeger
     ? initTracer({ serviceName: 'javascript-typescript-langserver', sampler: { type: 'const', param: 1 } })
     : new Tracer()
@@ -33,6 +47,8 @@ const tracer = program.enableJaeger
 const options: TypeScriptServiceOptions & MessageLogOptions & RegisterLanguageHandlerOptions = {
     strict: program.strict,
     logMessages: program.trace,
+    redactPatterns: program.redact,
+    slowRequestThreshold: program.slowRequestThreshold,
     logger,
     tracer,
 }
@@ -42,12 +58,29 @@ const messageWriter = new MessageWriter(process.stdout, options)
 const remoteClient = new RemoteLanguageClient(messageEmitter, messageWriter)
 const service = new TypeScriptService(remoteClient, options)
 
-// Add an exit notification handler to kill the process
+/** Whether the service was initialized and not shut down yet, so it needs to be shut down on termination */
+let initialized = false
+
+// Track the lifecycle of the service and kill the process on the exit notification
 messageEmitter.on('message', message => {
+    if (isRequestMessage(message) && (message.method === 'initialize' || message.method === 'shutdown')) {
+        initialized = message.method === 'initialize'
+    }
     if (isNotificationMessage(message) && message.method === 'exit') {
         logger.log(`Exit notification`)
         process.exit(0)
     }
 })
 
+// On termination, shut the service down and exit (pending requests end with the process)
+for (const [signal, exitCode] of TERMINATION_SIGNALS) {
+    process.on(signal, () => {
+        logger.log(`Received ${signal}, shutting down`)
+        if (initialized) {
+            service.shutdown()
+        }
+        process.exit(exitCode)
+    })
+}
+
 registerLanguageHandler(messageEmitter, messageWriter, service, options)
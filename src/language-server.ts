@@ -1,7 +1,7 @@
 #!/usr/bin/env node
 
 import { Tracer } from 'opentracing'
-import { FileLogger, StdioLogger } from './logging'
+import { FileLogger, isLogLevel, LevelLogger, StdioLogger } from './logging'
 import { serve, ServeOptions } from './server'
 import { TypeScriptService, TypeScriptServiceOptions } from './typescript-service'
 const program = require('commander')
@@ -23,14 +23,29 @@ program
     .option('-t, --trace', 'print all requests and responses')
     .option('-l, --logfile [file]', 'log to this file')
     .option('-j, --enable-jaeger', 'enable OpenTracing through Jaeger')
+    .option('--slow-request-threshold [ms]', 'log requests that take longer than this amount of milliseconds', parseInt)
+    .option('--log-level <level>', 'only log messages of this level or above (debug, info, warn, error)', 'debug')
+    .option(
+        '--redact <regexp>',
+        'redact secrets matching this regular expression from traced messages and spans (repeatable)',
+        (pattern: string, patterns: RegExp[]) => [...patterns, new RegExp(pattern, 'g')],
+        []
+    )
     .parse(process.argv)
 
+if (!isLogLevel(program.logLevel)) {
+    process.stderr.write(`Invalid log level ${program.logLevel}, expected one of debug, info, warn, error\n`)
+    process.exit(1)
+}
+
 const options: ServeOptions & TypeScriptServiceOptions = {
     clusterSize: program.cluster || numCPUs,
     lspPort: program.port || defaultLspPort,
     strict: program.strict,
     logMessages: program.trace,
-    logger: program.logfile ? new FileLogger(program.logfile) : new StdioLogger(),
+    redactPatterns: program.redact,
+    slowRequestThreshold: program.slowRequestThreshold,
+    logger: new LevelLogger(program.logfile ? new FileLogger(program.logfile) : new StdioLogger(), program.logLevel),
     tracer: program.enableJaeger
         ? initTracer({ serviceName: 'javascript-typescript-langserver', sampler: { type: 'const', param: 1 } })
         : new Tracer(),
@@ -11,6 +11,21 @@ export interface Logger {
     error(...values: any[]): void
 }
 
+/**
+ * The levels a Logger can log at, from the most to the least verbose.
+ * `Logger.log()` logs at the `debug` level.
+ */
+export type LogLevel = 'debug' | 'info' | 'warn' | 'error'
+
+const LOG_LEVELS: LogLevel[] = ['debug', 'info', 'warn', 'error']
+
+/**
+ * Returns true if the passed string is a valid LogLevel
+ */
+export function isLogLevel(candidate: string): candidate is LogLevel {
+    return LOG_LEVELS.includes(candidate as LogLevel)
+}
+
 /**
  * Formats values to a message by pretty-printing objects
  */
@@ -152,6 +167,43 @@ export class PrefixedLogger {
     }
 }
 
+/**
+ * Logger implementation that wraps another logger and drops all messages below a minimum level
+ */
+export class LevelLogger {
+    private minLevel: number
+
+    /**
+     * @param logger The logger to pass messages of the minimum level or above to
+     * @param level The minimum level
+     */
+    constructor(private logger: Logger, level: LogLevel) {
+        this.minLevel = LOG_LEVELS.indexOf(level)
+    }
+
+    public log(...values: any[]): void {
+        if (this.minLevel <= LOG_LEVELS.indexOf('debug')) {
+            this.logger.log(...values)
+        }
+    }
+
+    public info(...values: any[]): void {
+        if (this.minLevel <= LOG_LEVELS.indexOf('info')) {
+            this.logger.info(...values)
+        }
+    }
+
+    public warn(...values: any[]): void {
+        if (this.minLevel <= LOG_LEVELS.indexOf('warn')) {
+            this.logger.warn(...values)
+        }
+    }
+
+    public error(...values: any[]): void {
+        this.logger.error(...values)
+    }
+}
+
 /**
  * Logger implementation that does nothing
  */
@@ -101,6 +101,26 @@ export class InMemoryFileSystem extends EventEmitter implements ts.ParseConfigHo
         this.emit('add', uri, content)
     }
 
+    /**
+     * Removes a file from the local cache, e.g. because it was deleted
+     *
+     * @param uri The URI of the file
+     */
+    public remove(uri: string): void {
+        this.files.delete(uri)
+        // Remove from directory tree
+        const filePath = uri2path(uri)
+        const components = filePath.split(/[\/\\]/).filter(c => c)
+        let node: FileSystemNode | undefined = this.rootNode
+        for (const component of components.slice(0, -1)) {
+            node = node.children.get(component)
+            if (!node) {
+                return
+            }
+        }
+        node.children.delete(components[components.length - 1])
+    }
+
     /**
      * Returns true if the given file is known to exist in the workspace (content loaded or not)
      *
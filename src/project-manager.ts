@@ -574,6 +574,11 @@ export class ProjectManager implements Disposable {
      */
     private pluginSettings?: PluginSettings
 
+    /**
+     * Catch-all configurations for the workspace root, used while there is no [tj]sconfig.json of their type
+     */
+    private fallbackConfigs: { js?: ProjectConfiguration; ts?: ProjectConfiguration } = {}
+
     /**
      * @param rootPath root path as passed to `initialize`
      * @param inMemoryFileSystem File system that keeps structure and contents in memory
@@ -599,9 +604,8 @@ export class ProjectManager implements Disposable {
         const documentRegistry = ts.createDocumentRegistry()
 
         // Create catch-all fallback configs in case there are no tsconfig.json files
-        // They are removed once at least one tsconfig.json is found
+        // They are removed once at least one tsconfig.json is found, and restored once all of them were deleted
         const trimmedRootPath = this.rootPath.replace(/[\\\/]+$/, '')
-        const fallbackConfigs: { js?: ProjectConfiguration; ts?: ProjectConfiguration } = {}
         for (const configType of ['js', 'ts'] as ConfigType[]) {
             const configs = this.configs[configType]
             const tsConfig: any = {
@@ -624,7 +628,7 @@ export class ProjectManager implements Disposable {
                 this.logger
             )
             configs.set(trimmedRootPath, config)
-            fallbackConfigs[configType] = config
+            this.fallbackConfigs[configType] = config
         }
 
         // Whenever a file with content is added to the InMemoryFileSystem, check if it's a tsconfig.json and add a new ProjectConfiguration
@@ -654,7 +658,7 @@ export class ProjectManager implements Disposable {
                         )
                     )
                     // Remove catch-all config (if exists)
-                    if (configs.get(trimmedRootPath) === fallbackConfigs[configType]) {
+                    if (configs.get(trimmedRootPath) === this.fallbackConfigs[configType]) {
                         configs.delete(trimmedRootPath)
                     }
                 })
@@ -1057,16 +1061,8 @@ export class ProjectManager implements Disposable {
      * @param uri file's URI
      */
     public didClose(uri: string, span = new Span()): void {
-        const filePath = uri2path(uri)
         this.inMemoryFs.didClose(uri)
-        let version = this.versions.get(uri) || 0
-        this.versions.set(uri, ++version)
-        const config = this.getConfigurationIfExists(filePath)
-        if (!config) {
-            return
-        }
-        config.ensureConfigFile(span)
-        config.getHost().incProjectVersion()
+        this.invalidateVersion(uri, false, span)
     }
 
     /**
@@ -1075,8 +1071,78 @@ export class ProjectManager implements Disposable {
      * @param text file's content
      */
     public didChange(uri: string, text: string, span = new Span()): void {
-        const filePath = uri2path(uri)
         this.inMemoryFs.didChange(uri, text)
+        this.invalidateVersion(uri, true, span)
+    }
+
+    /**
+     * Called when file was saved by client
+     * @param uri file's URI
+     */
+    public didSave(uri: string): void {
+        this.inMemoryFs.didSave(uri)
+    }
+
+    /**
+     * Called when a file the client does not manage was created or changed, e.g. on disk.
+     * Refetches the file content and invalidates compiled version.
+     * If the file is a [tj]sconfig.json or package.json file, invalidates the module structure instead.
+     * @param uri file's URI
+     */
+    public didChangeWatchedFile(uri: string, span = new Span()): Observable<never> {
+        this.updater.invalidate(uri)
+        this.invalidateReferencedFiles(uri)
+        return this.updater.fetch(uri, span).concat(
+            Observable.defer(() => {
+                if (isConfigFile(uri) || isPackageJsonFile(uri)) {
+                    // A new configuration for a created [tj]sconfig.json was added by the 'add' listener
+                    this.invalidateConfigurations()
+                } else {
+                    // Make sure created files are added to the program
+                    this.invalidateVersion(uri, isJSTSFile(uri), span)
+                }
+                return Observable.empty<never>()
+            })
+        )
+    }
+
+    /**
+     * Called when a file the client does not manage was deleted, e.g. on disk.
+     * Removes the file from the in-memory file system and invalidates compiled version.
+     * If the file is a [tj]sconfig.json or package.json file, invalidates the module structure instead.
+     * @param uri file's URI
+     */
+    public didDeleteWatchedFile(uri: string, span = new Span()): void {
+        this.updater.invalidate(uri)
+        this.invalidateReferencedFiles(uri)
+        this.inMemoryFs.remove(uri)
+        if (isConfigFile(uri)) {
+            // Files of the deleted configuration fall back to the configuration of a parent folder
+            const filePath = uri2path(uri)
+            const pos = filePath.search(LAST_FORWARD_OR_BACKWARD_SLASH)
+            const configType = this.getConfigurationType(filePath)
+            const configs = this.configs[configType]
+            configs.delete(pos <= 0 ? '' : filePath.substring(0, pos))
+            // Restore the catch-all config if no [tj]sconfig.json of this type is left
+            const fallbackConfig = this.fallbackConfigs[configType]
+            if (configs.size === 0 && fallbackConfig) {
+                configs.set(this.rootPath.replace(/[\\\/]+$/, ''), fallbackConfig)
+            }
+        }
+        if (isConfigFile(uri) || isPackageJsonFile(uri)) {
+            this.invalidateConfigurations()
+        } else {
+            this.invalidateVersion(uri, false, span)
+        }
+    }
+
+    /**
+     * Increments the version of a file and the project it belongs to, so that TypeScript picks up new content
+     * @param uri file's URI
+     * @param ensureSourceFile whether to add the file to the project if it is not part of it yet
+     */
+    private invalidateVersion(uri: string, ensureSourceFile: boolean, span = new Span()): void {
+        const filePath = uri2path(uri)
         let version = this.versions.get(uri) || 0
         this.versions.set(uri, ++version)
         const config = this.getConfigurationIfExists(filePath)
@@ -1084,16 +1150,22 @@ export class ProjectManager implements Disposable {
             return
         }
         config.ensureConfigFile(span)
-        config.ensureSourceFile(filePath)
+        if (ensureSourceFile) {
+            config.ensureSourceFile(filePath)
+        }
         config.getHost().incProjectVersion()
     }
 
     /**
-     * Called when file was saved by client
-     * @param uri file's URI
+     * Resets all configurations and requires the module structure to be ensured again,
+     * e.g. after a [tj]sconfig.json or package.json file changed
      */
-    public didSave(uri: string): void {
-        this.inMemoryFs.didSave(uri)
+    private invalidateConfigurations(): void {
+        this.invalidateModuleStructure()
+        for (const config of this.configurations()) {
+            config.reset()
+        }
+        this.invalidateReferencedFiles()
     }
 
     /**
@@ -1,7 +1,7 @@
 import { Operation } from 'fast-json-patch'
 import * as vscode from 'vscode-languageserver'
 
-export interface InitializeParams extends vscode.InitializeParams {
+export interface InitializeParams extends vscode.InitializeParams, WorkDoneProgressParams {
     capabilities: ClientCapabilities
 }
 
@@ -34,6 +34,27 @@ export interface ClientCapabilities extends vscode.ClientCapabilities {
      * The client supports receiving the result solely through $/partialResult notifications for requests from the client to the server.
      */
     streaming?: boolean
+
+    /**
+     * Window specific client capabilities.
+     */
+    window?: {
+        /**
+         * Whether the client supports server initiated progress using the window/workDoneProgress/create request.
+         */
+        workDoneProgress?: boolean
+    }
+
+    /**
+     * General client capabilities.
+     */
+    general?: {
+        /**
+         * The position encodings supported by the client, in order of preference.
+         * If omitted, only `utf-16` is supported.
+         */
+        positionEncodings?: PositionEncodingKind[]
+    }
 }
 
 export interface ServerCapabilities extends vscode.ServerCapabilities {
@@ -46,6 +67,66 @@ export interface ServerCapabilities extends vscode.ServerCapabilities {
      * The server supports receiving results solely through $/partialResult notifications for requests from the server to the client.
      */
     streaming?: boolean
+
+    /**
+     * The position encoding the server picked from the encodings offered by the client.
+     */
+    positionEncoding?: PositionEncodingKind
+
+    /**
+     * Workspace specific server capabilities, extended with file operations
+     */
+    workspace?: NonNullable<vscode.ServerCapabilities['workspace']> & {
+        /**
+         * The server is interested in file operation notifications and requests.
+         */
+        fileOperations?: FileOperationOptions
+    }
+}
+
+/**
+ * The file operations the server is interested in. Not yet part of the protocol version we depend on.
+ */
+export interface FileOperationOptions {
+    /**
+     * The server is interested in receiving workspace/willRenameFiles requests.
+     */
+    willRename?: FileOperationRegistrationOptions
+}
+
+/**
+ * The options to register for file operations
+ */
+export interface FileOperationRegistrationOptions {
+    /**
+     * The actual filters.
+     */
+    filters: FileOperationFilter[]
+}
+
+/**
+ * A filter to describe in which file operation requests or notifications the server is interested in.
+ */
+export interface FileOperationFilter {
+    /**
+     * A Uri like `file` or `untitled`.
+     */
+    scheme?: string
+
+    /**
+     * The actual file operation pattern.
+     */
+    pattern: {
+        /**
+         * The glob pattern to match, e.g. `**\/*.ts`
+         */
+        glob: string
+
+        /**
+         * Whether to match files or folders with this pattern. Matches both if undefined.
+         */
+        matches?: 'file' | 'folder'
+    }
 }
 
 export interface InitializeResult extends vscode.InitializeResult {
@@ -116,7 +197,7 @@ export interface SymbolDescriptor {
  * workspace/symbols endpoint (an extension of the original LSP spec).
  * If both properties are set, the requirements are AND'd.
  */
-export interface WorkspaceSymbolParams {
+export interface WorkspaceSymbolParams extends WorkDoneProgressParams {
     /**
      * A non-empty query string.
      */
@@ -126,6 +207,12 @@ export interface WorkspaceSymbolParams {
      * A set of properties that describe the symbol to look up.
      */
     symbol?: Partial<SymbolDescriptor>
+
+    /**
+     * The maximum number of symbols to return.
+     * If not given, defaults to 100 for text queries and 1000 for symbol queries.
+     */
+    limit?: number
 }
 
 /*
@@ -133,7 +220,7 @@ export interface WorkspaceSymbolParams {
  * workspace/xreferences endpoint (an extension of the original LSP
  * spec).
  */
-export interface WorkspaceReferenceParams {
+export interface WorkspaceReferenceParams extends WorkDoneProgressParams {
     /**
      * Metadata about the symbol that is being searched for.
      */
@@ -145,6 +232,11 @@ export interface WorkspaceReferenceParams {
      * this object.
      */
     hints?: DependencyHints
+
+    /**
+     * The maximum number of references to return. If not given, all references are returned.
+     */
+    limit?: number
 }
 
 export interface SymbolLocationInformation {
@@ -236,6 +328,209 @@ export interface PartialResultParams {
     patch: Operation[]
 }
 
+/**
+ * A token chosen by the client to report progress on, e.g. for a request it sent.
+ * Not to be confused with the request id.
+ */
+export type ProgressToken = number | string
+
+/**
+ * Mixin for request params that allow the client to pass a token the server reports work done progress on
+ */
+export interface WorkDoneProgressParams {
+    /**
+     * An optional token that a server can use to report work done progress.
+     */
+    workDoneToken?: ProgressToken
+}
+
+/**
+ * Sent as the value of the first $/progress notification for a token
+ */
+export interface WorkDoneProgressBegin {
+    kind: 'begin'
+
+    /**
+     * Mandatory title of the progress operation, e.g. "Indexing"
+     */
+    title: string
+
+    /**
+     * Optional, more detailed associated progress message
+     */
+    message?: string
+
+    /**
+     * Optional progress percentage to display (value 100 is considered 100%)
+     */
+    percentage?: number
+}
+
+/**
+ * Sent as the value of $/progress notifications between begin and end
+ */
+export interface WorkDoneProgressReport {
+    kind: 'report'
+
+    /**
+     * Optional, more detailed associated progress message
+     */
+    message?: string
+
+    /**
+     * Optional progress percentage to display (value 100 is considered 100%)
+     */
+    percentage?: number
+}
+
+/**
+ * Sent as the value of the last $/progress notification for a token
+ */
+export interface WorkDoneProgressEnd {
+    kind: 'end'
+
+    /**
+     * Optional, a final message indicating for example the outcome of the operation
+     */
+    message?: string
+}
+
+/**
+ * Params of the window/workDoneProgress/create request the server sends to create a progress token it reports on
+ */
+export interface WorkDoneProgressCreateParams {
+    /**
+     * The token to be used to report progress.
+     */
+    token: ProgressToken
+}
+
+export interface ProgressParams<T = WorkDoneProgressBegin | WorkDoneProgressReport | WorkDoneProgressEnd> {
+    /**
+     * The progress token provided by the client or server
+     */
+    token: ProgressToken
+
+    /**
+     * The progress data
+     */
+    value: T
+}
+
+/**
+ * Arguments of the `refactor` command, which computes and applies the edits of a TypeScript refactoring
+ */
+export interface RefactorCommandArguments {
+    /**
+     * The document the refactoring was requested for
+     */
+    textDocument: vscode.TextDocumentIdentifier
+
+    /**
+     * The range the refactoring applies to
+     */
+    range: vscode.Range
+
+    /**
+     * The name of the refactoring as returned by TypeScript, e.g. "Extract Symbol"
+     */
+    refactor: string
+
+    /**
+     * The name of the refactoring action as returned by TypeScript, e.g. "function_scope_0"
+     */
+    action: string
+}
+
+/**
+ * Represents information on a file/folder rename
+ */
+export interface FileRename {
+    /**
+     * A file:// URI for the original location of the file/folder being renamed.
+     */
+    oldUri: string
+
+    /**
+     * A file:// URI for the new location of the file/folder being renamed.
+     */
+    newUri: string
+}
+
+/**
+ * The parameters sent in notifications/requests for user-initiated renames of files
+ */
+export interface RenameFilesParams {
+    /**
+     * An array of all files/folders renamed in this operation. When a folder is renamed, only
+     * the folder will be included, and not its children.
+     */
+    files: FileRename[]
+}
+
+/**
+ * A type indicating how positions are encoded, specifically what column offsets mean.
+ *
+ * - `utf-8`: character offsets count UTF-8 code units (bytes)
+ * - `utf-16`: character offsets count UTF-16 code units. This is the default and must always be supported
+ * - `utf-32`: character offsets count UTF-32 code units (Unicode code points)
+ */
+export type PositionEncodingKind = 'utf-8' | 'utf-16' | 'utf-32'
+
+/**
+ * The diagnostic tags. Not yet part of the protocol version we depend on.
+ */
+export enum DiagnosticTag {
+    /**
+     * Unused or unnecessary code.
+     * Clients are allowed to render diagnostics with this tag faded out instead of having an error squiggle.
+     */
+    Unnecessary = 1,
+
+    /**
+     * Deprecated or obsolete code.
+     * Clients are allowed to rendered diagnostics with this tag strike through.
+     */
+    Deprecated = 2,
+}
+
+/**
+ * Structure to capture a description for an error code.
+ */
+export interface CodeDescription {
+    /**
+     * An URI to open with more information about the diagnostic error.
+     */
+    href: string
+}
+
+/**
+ * Represents a related message and source code location for a diagnostic, e.g. the declaration
+ * a "declared here" message points to.
+ */
+export type DiagnosticRelatedInformation = vscode.DiagnosticRelatedInformation
+
+/**
+ * Extension of vscode's Diagnostic interface with the fields of later protocol versions
+ */
+export interface Diagnostic extends vscode.Diagnostic {
+    /**
+     * An optional property to describe the error code.
+     */
+    codeDescription?: CodeDescription
+
+    /**
+     * Additional metadata about the diagnostic.
+     */
+    tags?: DiagnosticTag[]
+
+    /**
+     * An array of related diagnostic information, e.g. when symbol-names within a scope collide
+     * all definitions can be marked via this property.
+     */
+    relatedInformation?: DiagnosticRelatedInformation[]
+}
+
 /**
  * Restriction on vscode's CompletionItem interface
  */
@@ -2,13 +2,19 @@ import * as cluster from 'cluster'
 import * as net from 'net'
 import { Tracer } from 'opentracing'
 import { isNotificationMessage } from 'vscode-jsonrpc/lib/messages'
-import { MessageEmitter, MessageLogOptions, MessageWriter, registerLanguageHandler } from './connection'
+import {
+    MessageEmitter,
+    MessageLogOptions,
+    MessageWriter,
+    registerLanguageHandler,
+    RegisterLanguageHandlerOptions,
+} from './connection'
 import { RemoteLanguageClient } from './lang-handler'
 import { Logger, PrefixedLogger, StdioLogger } from './logging'
 import { TypeScriptService } from './typescript-service'
 
 /** Options to `serve()` */
-export interface ServeOptions extends MessageLogOptions {
+export interface ServeOptions extends MessageLogOptions, RegisterLanguageHandlerOptions {
     /** Amount of workers to spawn */
     clusterSize: number
 
@@ -19,6 +25,21 @@ export interface ServeOptions extends MessageLogOptions {
     tracer?: Tracer
 }
 
+/**
+ * Signals upon which the server terminates gracefully, mapped to the exit code to use.
+ * Follows the convention of 128 + signal number, so supervisors can tell the process was interrupted.
+ */
+export const TERMINATION_SIGNALS = new Map([['SIGINT', 130], ['SIGTERM', 143]])
+
+/** Time window in milliseconds in which worker crashes are counted to detect a crash loop */
+const CRASH_LOOP_WINDOW = 60 * 1000
+
+/** Amount of worker crashes inside the time window after which workers are considered crash-looping */
+const CRASH_LOOP_THRESHOLD = 5
+
+/** Delay in milliseconds before restarting a worker while crash-looping */
+const CRASH_LOOP_RESTART_DELAY = 10 * 1000
+
 /**
  * Creates a Logger prefixed with master or worker ID
  *
@@ -42,6 +63,32 @@ export function serve(
     const logger = options.logger || createClusterLogger()
     if (options.clusterSize > 1 && cluster.isMaster) {
         logger.log(`Spawning ${options.clusterSize} workers`)
+        /** Whether the master received a termination signal and workers should not be restarted anymore */
+        let terminating = false
+        /** Exit code for the termination signal the master received */
+        let exitCode = 0
+        /** Amount of workers that did not exit yet after terminating */
+        let remainingWorkers = 0
+        for (const [signal, signalExitCode] of TERMINATION_SIGNALS) {
+            process.on(signal, () => {
+                if (terminating) {
+                    return
+                }
+                terminating = true
+                exitCode = signalExitCode
+                const ids = Object.keys(cluster.workers)
+                remainingWorkers = ids.length
+                logger.info(`Received ${signal}, terminating ${remainingWorkers} workers`)
+                if (remainingWorkers === 0) {
+                    process.exit(exitCode)
+                }
+                for (const id of ids) {
+                    cluster.workers[id].kill()
+                }
+            })
+        }
+        /** Timestamps of recent worker crashes, to detect crash loops */
+        let crashTimes: number[] = []
         cluster.on('online', worker => {
             logger.log(`Worker ${worker.id} (PID ${worker.process.pid}) online`)
         })
@@ -50,7 +97,33 @@ export function serve(
                 worker.process.pid
             }) exited from signal ${signal} with code ${code}`
 
+            if (terminating) {
+                logger.info(baseLogMessage)
+                if (--remainingWorkers === 0) {
+                    process.exit(exitCode)
+                }
+                return
+            }
+
             if (!worker.exitedAfterDisconnect) {
+                const now = Date.now()
+                crashTimes = crashTimes.filter(time => now - time < CRASH_LOOP_WINDOW).concat(now)
+                if (crashTimes.length >= CRASH_LOOP_THRESHOLD) {
+                    // Don't hog the CPU with restarts that will likely crash again
+                    const windowSeconds = CRASH_LOOP_WINDOW / 1000
+                    const delaySeconds = CRASH_LOOP_RESTART_DELAY / 1000
+                    logger.error(
+                        `${baseLogMessage}, workers are crash-looping ` +
+                            `(${crashTimes.length} crashes in the last ${windowSeconds}s), ` +
+                            `restarting in ${delaySeconds}s`
+                    )
+                    setTimeout(() => {
+                        if (!terminating) {
+                            cluster.fork()
+                        }
+                    }, CRASH_LOOP_RESTART_DELAY)
+                    return
+                }
                 logger.error(`${baseLogMessage}, restarting`)
                 cluster.fork()
                 return
@@ -63,9 +136,15 @@ export function serve(
         }
     } else {
         let counter = 1
+        /** Open connections, to be closed on termination */
+        const sockets = new Set<net.Socket>()
         const server = net.createServer(socket => {
             const id = counter++
             logger.log(`Connection ${id} accepted`)
+            sockets.add(socket)
+            socket.on('close', () => {
+                sockets.delete(socket)
+            })
 
             const messageEmitter = new MessageEmitter(socket as NodeJS.ReadableStream, options)
             const messageWriter = new MessageWriter(socket, options)
@@ -86,5 +165,19 @@ export function serve(
         server.listen(options.lspPort, () => {
             logger.info(`Listening for incoming LSP connections on ${options.lspPort}`)
         })
+
+        // On termination, stop accepting connections and close open ones,
+        // which cancels their pending requests and shuts their services down
+        for (const [signal, exitCode] of TERMINATION_SIGNALS) {
+            process.on(signal, () => {
+                logger.info(`Received ${signal}, closing ${sockets.size} connections`)
+                server.close(() => {
+                    process.exit(exitCode)
+                })
+                for (const socket of sockets) {
+                    socket.destroy()
+                }
+            })
+        }
     }
 }
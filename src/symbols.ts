@@ -1,8 +1,8 @@
 import * as ts from 'typescript'
 import { SymbolInformation, SymbolKind } from 'vscode-languageserver-types'
 import { isTypeScriptLibrary } from './memfs'
-import { SymbolDescriptor } from './request-type'
-import { path2uri, toUnixPath } from './util'
+import { PositionEncodingKind, SymbolDescriptor } from './request-type'
+import { offsetToPosition, path2uri, toUnixPath } from './util'
 
 /**
  * Returns a SymbolDescriptor for a ts.DefinitionInfo
@@ -45,11 +45,13 @@ export function locationUri(filePath: string): string {
  * Returns an LSP SymbolInformation for a TypeScript NavigateToItem
  *
  * @param rootPath The workspace rootPath to remove from symbol names and containerNames
+ * @param encoding The encoding to count the characters of the location in
  */
 export function navigateToItemToSymbolInformation(
     item: ts.NavigateToItem,
     program: ts.Program,
-    rootPath: string
+    rootPath: string,
+    encoding: PositionEncodingKind = 'utf-16'
 ): SymbolInformation {
     const sourceFile = program.getSourceFile(item.fileName)
     if (!sourceFile) {
@@ -61,8 +63,8 @@ export function navigateToItemToSymbolInformation(
         location: {
             uri: locationUri(sourceFile.fileName),
             range: {
-                start: ts.getLineAndCharacterOfPosition(sourceFile, item.textSpan.start),
-                end: ts.getLineAndCharacterOfPosition(sourceFile, item.textSpan.start + item.textSpan.length),
+                start: offsetToPosition(sourceFile, item.textSpan.start, encoding),
+                end: offsetToPosition(sourceFile, item.textSpan.start + item.textSpan.length, encoding),
             },
         },
     }
@@ -137,12 +139,15 @@ export function stringtoSymbolKind(kind: string): SymbolKind {
 
 /**
  * Returns an LSP SymbolInformation for a TypeScript NavigationTree node
+ *
+ * @param encoding The encoding to count the characters of the location in
  */
 export function navigationTreeToSymbolInformation(
     tree: ts.NavigationTree,
     parent: ts.NavigationTree | undefined,
     sourceFile: ts.SourceFile,
-    rootPath: string
+    rootPath: string,
+    encoding: PositionEncodingKind = 'utf-16'
 ): SymbolInformation {
     const span = tree.spans[0]
     if (!span) {
@@ -154,8 +159,8 @@ export function navigationTreeToSymbolInformation(
         location: {
             uri: locationUri(sourceFile.fileName),
             range: {
-                start: ts.getLineAndCharacterOfPosition(sourceFile, span.start),
-                end: ts.getLineAndCharacterOfPosition(sourceFile, span.start + span.length),
+                start: offsetToPosition(sourceFile, span.start, encoding),
+                end: offsetToPosition(sourceFile, span.start + span.length, encoding),
             },
         },
     }
@@ -1,15 +1,26 @@
 import * as assert from 'assert'
 import { EventEmitter } from 'events'
 import { Operation } from 'fast-json-patch'
-import { Span } from 'opentracing'
+import { Span, Tracer } from 'opentracing'
 import { Observable, Subject } from 'rxjs'
 import * as sinon from 'sinon'
 import { PassThrough } from 'stream'
 import { ErrorCodes } from 'vscode-jsonrpc'
-import { MessageEmitter, MessageWriter, registerLanguageHandler } from '../connection'
+import { MessageEmitter, MessageWriter, redact, registerLanguageHandler } from '../connection'
 import { NoopLogger } from '../logging'
 import { TypeScriptService } from '../typescript-service'
 
+/**
+ * Stubs initialize and shutdown of the handler, initializes the connection and resets the writer,
+ * so that tests only see the messages written for the requests they send afterwards
+ */
+function initializeConnection(emitter: EventEmitter, handler: any, writer: { write: sinon.SinonSpy }): void {
+    handler.initialize = sinon.stub().returns(Observable.of({ op: 'add', path: '', value: { capabilities: {} } }))
+    handler.shutdown = sinon.stub().returns(Observable.of({ op: 'add', path: '', value: null }))
+    emitter.emit('message', { jsonrpc: '2.0', id: 0, method: 'initialize', params: { capabilities: {} } })
+    writer.write.resetHistory()
+}
+
 describe('connection', () => {
     describe('registerLanguageHandler()', () => {
         it('should return MethodNotFound error when the method does not exist on handler', async () => {
@@ -23,6 +34,7 @@ describe('connection', () => {
                 (writer as any) as MessageWriter,
                 handler as TypeScriptService
             )
+            initializeConnection(emitter, handler, writer)
             const params = [1, 1]
             emitter.emit('message', { jsonrpc: '2.0', id: 1, method: 'whatever', params })
             sinon.assert.calledOnce(writer.write)
@@ -38,6 +50,7 @@ describe('connection', () => {
                 write: sinon.spy(),
             }
             registerLanguageHandler(emitter as MessageEmitter, writer as any, handler as any)
+            initializeConnection(emitter, handler, writer)
             const params = [1, 1]
             emitter.emit('message', { jsonrpc: '2.0', id: 1, method: 'textDocument/hover', params })
             sinon.assert.notCalled(handler._privateMethod)
@@ -66,6 +79,36 @@ describe('connection', () => {
                 sinon.match({ jsonrpc: '2.0', id: 1, result: { capabilities: {} } })
             )
         })
+        it('should report work done progress on the workDoneToken of a request before sending the result', async () => {
+            const handler: {
+                [K in keyof TypeScriptService]: TypeScriptService[K] & sinon.SinonStub
+            } = sinon.createStubInstance(TypeScriptService)
+            handler.textDocumentHover.returns(Observable.of({ op: 'add', path: '', value: 2 }))
+            const emitter = new EventEmitter()
+            const writer = {
+                write: sinon.spy(),
+            }
+            registerLanguageHandler(emitter as MessageEmitter, writer as any, handler as any)
+            initializeConnection(emitter, handler, writer)
+            emitter.emit('message', {
+                jsonrpc: '2.0',
+                id: 1,
+                method: 'textDocument/hover',
+                params: { workDoneToken: 'abc' },
+            })
+            sinon.assert.calledThrice(writer.write)
+            assert.deepEqual(writer.write.args[0], [
+                {
+                    jsonrpc: '2.0',
+                    method: '$/progress',
+                    params: { token: 'abc', value: { kind: 'begin', title: 'textDocument/hover' } },
+                },
+            ])
+            assert.deepEqual(writer.write.args[1], [
+                { jsonrpc: '2.0', method: '$/progress', params: { token: 'abc', value: { kind: 'end' } } },
+            ])
+            assert.deepEqual(writer.write.args[2], [{ jsonrpc: '2.0', id: 1, result: 2 }])
+        })
         it('should ignore exit notifications', async () => {
             const handler = {
                 exit: sinon.spy(),
@@ -105,6 +148,164 @@ describe('connection', () => {
             emitter.emit('message', { jsonrpc: '2.0', id: 1 })
             sinon.assert.calledOnce(logger.error)
         })
+        it('should return ServerNotInitialized error for requests before initialize', async () => {
+            const handler: TypeScriptService = Object.create(TypeScriptService.prototype)
+            const hoverStub = sinon.stub(handler, 'textDocumentHover')
+            const emitter = new EventEmitter()
+            const writer = {
+                write: sinon.spy(),
+            }
+            registerLanguageHandler(emitter as MessageEmitter, writer as any, handler as TypeScriptService)
+            emitter.emit('message', { jsonrpc: '2.0', id: 1, method: 'textDocument/hover', params: [1, 2] })
+            sinon.assert.notCalled(hoverStub)
+            sinon.assert.calledOnce(writer.write)
+            sinon.assert.calledWithExactly(
+                writer.write,
+                sinon.match({ jsonrpc: '2.0', id: 1, error: { code: ErrorCodes.ServerNotInitialized } })
+            )
+        })
+        it('should drop notifications before initialize', async () => {
+            const handler: TypeScriptService = Object.create(TypeScriptService.prototype)
+            const didOpenStub = sinon.stub(handler, 'textDocumentDidOpen')
+            const emitter = new EventEmitter()
+            const writer = {
+                write: sinon.spy(),
+            }
+            registerLanguageHandler(emitter as MessageEmitter, writer as any, handler as TypeScriptService)
+            emitter.emit('message', { jsonrpc: '2.0', method: 'textDocument/didOpen', params: {} })
+            sinon.assert.notCalled(didOpenStub)
+            sinon.assert.notCalled(writer.write)
+        })
+        it('should return InvalidRequest error for a second initialize request', async () => {
+            const handler: TypeScriptService = Object.create(TypeScriptService.prototype)
+            const emitter = new EventEmitter()
+            const writer = {
+                write: sinon.spy(),
+            }
+            registerLanguageHandler(emitter as MessageEmitter, writer as any, handler as TypeScriptService)
+            initializeConnection(emitter, handler, writer)
+            emitter.emit('message', { jsonrpc: '2.0', id: 1, method: 'initialize', params: { capabilities: {} } })
+            sinon.assert.calledOnce(handler.initialize as sinon.SinonStub)
+            sinon.assert.calledOnce(writer.write)
+            sinon.assert.calledWithExactly(
+                writer.write,
+                sinon.match({ jsonrpc: '2.0', id: 1, error: { code: ErrorCodes.InvalidRequest } })
+            )
+        })
+        it('should return InvalidRequest error for requests after shutdown', async () => {
+            const handler: TypeScriptService = Object.create(TypeScriptService.prototype)
+            const hoverStub = sinon.stub(handler, 'textDocumentHover')
+            const emitter = new EventEmitter()
+            const writer = {
+                write: sinon.spy(),
+            }
+            registerLanguageHandler(emitter as MessageEmitter, writer as any, handler as TypeScriptService)
+            initializeConnection(emitter, handler, writer)
+            emitter.emit('message', { jsonrpc: '2.0', id: 1, method: 'shutdown', params: {} })
+            writer.write.resetHistory()
+            emitter.emit('message', { jsonrpc: '2.0', id: 2, method: 'textDocument/hover', params: [1, 2] })
+            sinon.assert.notCalled(hoverStub)
+            sinon.assert.calledOnce(writer.write)
+            sinon.assert.calledWithExactly(
+                writer.write,
+                sinon.match({ jsonrpc: '2.0', id: 2, error: { code: ErrorCodes.InvalidRequest } })
+            )
+        })
+        it('should log requests that take longer than the slow request threshold', async () => {
+            const handler: TypeScriptService = Object.create(TypeScriptService.prototype)
+            sinon.stub(handler, 'textDocumentHover').returns(Observable.of({ op: 'add', path: '', value: 2 }))
+            const emitter = new EventEmitter()
+            const writer = {
+                write: sinon.spy(),
+            }
+            const logger = new NoopLogger() as NoopLogger & { warn: sinon.SinonStub }
+            registerLanguageHandler(emitter as MessageEmitter, writer as any, handler as TypeScriptService, {
+                logger,
+                slowRequestThreshold: 0,
+            })
+            initializeConnection(emitter, handler, writer)
+            sinon.stub(logger, 'warn')
+            emitter.emit('message', { jsonrpc: '2.0', id: 1, method: 'textDocument/hover', params: [1, 2] })
+            sinon.assert.calledOnce(logger.warn)
+            sinon.assert.calledWith(logger.warn, sinon.match(/^Slow request textDocument\/hover \(1\) took \d+ms/))
+        })
+        it('should not log slow requests if no slow request threshold is given', async () => {
+            const handler: TypeScriptService = Object.create(TypeScriptService.prototype)
+            sinon.stub(handler, 'textDocumentHover').returns(Observable.of({ op: 'add', path: '', value: 2 }))
+            const emitter = new EventEmitter()
+            const writer = {
+                write: sinon.spy(),
+            }
+            const logger = new NoopLogger() as NoopLogger & { warn: sinon.SinonStub }
+            sinon.stub(logger, 'warn')
+            registerLanguageHandler(emitter as MessageEmitter, writer as any, handler as TypeScriptService, { logger })
+            initializeConnection(emitter, handler, writer)
+            emitter.emit('message', { jsonrpc: '2.0', id: 1, method: 'textDocument/hover', params: [1, 2] })
+            sinon.assert.notCalled(logger.warn)
+        })
+        it('should tag the span of a request with the method and id', async () => {
+            const handler: TypeScriptService = Object.create(TypeScriptService.prototype)
+            sinon.stub(handler, 'textDocumentHover').returns(Observable.of({ op: 'add', path: '', value: 2 }))
+            const span = new Span()
+            const tracer = new Tracer()
+            sinon.stub(tracer, 'startSpan').returns(span)
+            const emitter = new EventEmitter()
+            const writer = {
+                write: sinon.spy(),
+            }
+            registerLanguageHandler(emitter as MessageEmitter, writer as any, handler as TypeScriptService, { tracer })
+            initializeConnection(emitter, handler, writer)
+            const setTag = sinon.spy(span, 'setTag')
+            const finish = sinon.spy(span, 'finish')
+            emitter.emit('message', { jsonrpc: '2.0', id: 1, method: 'textDocument/hover', params: [1, 2] })
+            sinon.assert.calledWith(setTag, 'method', 'textDocument/hover')
+            sinon.assert.calledWith(setTag, 'id', 1)
+            sinon.assert.calledOnce(finish)
+        })
+        it('should redact configured patterns from the params tag of a span', async () => {
+            const handler: TypeScriptService = Object.create(TypeScriptService.prototype)
+            sinon.stub(handler, 'textDocumentHover').returns(Observable.of({ op: 'add', path: '', value: 2 }))
+            const span = new Span()
+            const tracer = new Tracer()
+            sinon.stub(tracer, 'startSpan').returns(span)
+            const emitter = new EventEmitter()
+            const writer = {
+                write: sinon.spy(),
+            }
+            registerLanguageHandler(emitter as MessageEmitter, writer as any, handler as TypeScriptService, {
+                tracer,
+                redactPatterns: [/secret-\w+/g],
+            })
+            initializeConnection(emitter, handler, writer)
+            const setTag = sinon.spy(span, 'setTag')
+            emitter.emit('message', {
+                jsonrpc: '2.0',
+                id: 1,
+                method: 'textDocument/hover',
+                params: { token: 'secret-abc' },
+            })
+            sinon.assert.calledWith(setTag, 'params', "{ token: '[REDACTED]' }")
+        })
+        it('should finish the span of a notification and tag it with the error of the handler', async () => {
+            const handler: TypeScriptService = Object.create(TypeScriptService.prototype)
+            sinon.stub(handler, 'textDocumentDidOpen').returns(Promise.reject(new Error('Something happened')))
+            const span = new Span()
+            const tracer = new Tracer()
+            sinon.stub(tracer, 'startSpan').returns(span)
+            const emitter = new EventEmitter()
+            const writer = {
+                write: sinon.spy(),
+            }
+            registerLanguageHandler(emitter as MessageEmitter, writer as any, handler as TypeScriptService, { tracer })
+            initializeConnection(emitter, handler, writer)
+            const setTag = sinon.spy(span, 'setTag')
+            const finish = sinon.spy(span, 'finish')
+            emitter.emit('message', { jsonrpc: '2.0', method: 'textDocument/didOpen', params: {} })
+            await new Promise<void>(resolve => setTimeout(resolve, 0))
+            sinon.assert.calledWith(setTag, 'error', true)
+            sinon.assert.calledOnce(finish)
+            sinon.assert.notCalled(writer.write)
+        })
         it('should call a handler on request and send the result of the returned Observable', async () => {
             const handler: TypeScriptService = Object.create(TypeScriptService.prototype)
             const hoverStub = sinon
@@ -117,6 +318,7 @@ describe('connection', () => {
                 write: sinon.spy(),
             }
             registerLanguageHandler(emitter as MessageEmitter, writer as any, handler as TypeScriptService)
+            initializeConnection(emitter, handler, writer)
             const params = [1, 1]
             emitter.emit('message', { jsonrpc: '2.0', id: 1, method: 'textDocument/hover', params })
             sinon.assert.calledOnce(hoverStub)
@@ -138,6 +340,7 @@ describe('connection', () => {
                 write: sinon.spy(),
             }
             registerLanguageHandler(emitter as MessageEmitter, writer as any, handler as TypeScriptService)
+            initializeConnection(emitter, handler, writer)
             const params = [1, 1]
             emitter.emit('message', { jsonrpc: '2.0', id: 1, method: 'textDocument/hover', params })
             sinon.assert.calledOnce(hoverStub)
@@ -166,6 +369,7 @@ describe('connection', () => {
                 write: sinon.spy(),
             }
             registerLanguageHandler(emitter as MessageEmitter, writer as any, handler as TypeScriptService)
+            initializeConnection(emitter, handler, writer)
             emitter.emit('message', { jsonrpc: '2.0', id: 1, method: 'textDocument/hover', params: [1, 2] })
             sinon.assert.calledOnce(hoverStub)
             sinon.assert.calledWithExactly(hoverStub, [1, 2], sinon.match.instanceOf(Span))
@@ -181,6 +385,7 @@ describe('connection', () => {
                 write: sinon.spy(),
             }
             registerLanguageHandler(emitter as MessageEmitter, writer as any, handler as TypeScriptService)
+            initializeConnection(emitter, handler, writer)
             const params = [1, 1]
             emitter.emit('message', { jsonrpc: '2.0', id: 1, method: 'textDocument/hover', params })
             sinon.assert.calledOnce(hoverStub)
@@ -198,6 +403,7 @@ describe('connection', () => {
                 write: sinon.spy(),
             }
             registerLanguageHandler(emitter as MessageEmitter, writer as any, handler as TypeScriptService)
+            initializeConnection(emitter, handler, writer)
             const params = [1, 1]
             emitter.emit('message', { jsonrpc: '2.0', id: 1, method: 'textDocument/hover', params })
             sinon.assert.calledOnce(hoverStub)
@@ -221,6 +427,7 @@ describe('connection', () => {
                 write: sinon.spy(),
             }
             registerLanguageHandler(emitter as MessageEmitter, writer as any, handler as TypeScriptService)
+            initializeConnection(emitter, handler, writer)
             const params = [1, 1]
             emitter.emit('message', { jsonrpc: '2.0', id: 1, method: 'textDocument/hover', params })
             sinon.assert.calledOnce(hoverStub)
@@ -238,6 +445,7 @@ describe('connection', () => {
                 write: sinon.spy(),
             }
             registerLanguageHandler(emitter as MessageEmitter, writer as any, handler as TypeScriptService)
+            initializeConnection(emitter, handler, writer)
             const params = [1, 1]
             emitter.emit('message', { jsonrpc: '2.0', id: 1, method: 'textDocument/hover', params })
             sinon.assert.calledOnce(hoverStub)
@@ -290,7 +498,8 @@ describe('connection', () => {
                     write: sinon.spy(),
                 }
                 registerLanguageHandler(emitter as MessageEmitter, writer as any, handler as any)
-                emitter.emit('message', { jsonrpc: '2.0', id: 1, method: 'shutdown', params: {} })
+                emitter.emit('message', { jsonrpc: '2.0', id: 1, method: 'initialize', params: { capabilities: {} } })
+                emitter.emit('message', { jsonrpc: '2.0', id: 2, method: 'shutdown', params: {} })
 
                 sinon.assert.calledOnce(handler.shutdown)
                 emitter.emit(event)
@@ -397,6 +606,25 @@ describe('connection', () => {
             })
         })
     })
+    describe('redact()', () => {
+        it('should replace all matches of the patterns in nested strings', () => {
+            const message = {
+                jsonrpc: '2.0',
+                method: 'textDocument/didOpen',
+                params: { textDocument: { text: 'TOKEN=abc\nAPI_KEY=def\nfoo', version: 1 }, list: ['TOKEN=ghi'] },
+            }
+            assert.deepEqual(redact(message, [/(TOKEN|API_KEY)=\w+/g]), {
+                jsonrpc: '2.0',
+                method: 'textDocument/didOpen',
+                params: { textDocument: { text: '[REDACTED]\n[REDACTED]\nfoo', version: 1 }, list: ['[REDACTED]'] },
+            })
+            assert.equal(message.params.textDocument.text, 'TOKEN=abc\nAPI_KEY=def\nfoo', 'Expected no mutation')
+        })
+        it('should return the value unchanged if no patterns are given', () => {
+            const message = { jsonrpc: '2.0', method: 'whatever' }
+            assert.strictEqual(redact(message), message)
+        })
+    })
     describe('MessageEmitter', () => {
         it('should log messages if enabled', async () => {
             const logger = new NoopLogger() as NoopLogger & { log: sinon.SinonStub }
@@ -423,6 +651,17 @@ describe('connection', () => {
             sinon.assert.calledOnce(logger.log)
             sinon.assert.calledWith(logger.log, '<--')
         })
+        it('should redact configured patterns from logged messages', async () => {
+            const logger = new NoopLogger() as NoopLogger & { log: sinon.SinonStub }
+            sinon.stub(logger, 'log')
+            const writer = new MessageWriter(new PassThrough(), { logMessages: true, logger, redactPatterns: [/abc/g] })
+            writer.write({ jsonrpc: '2.0', method: 'whatever', params: { text: 'abc def' } })
+            sinon.assert.calledWith(logger.log, '<--', {
+                jsonrpc: '2.0',
+                method: 'whatever',
+                params: { text: '[REDACTED] def' },
+            })
+        })
         it('should not log messages if disabled', async () => {
             const logger = new NoopLogger() as NoopLogger & { log: sinon.SinonStub }
             sinon.stub(logger, 'log')
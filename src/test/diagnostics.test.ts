@@ -0,0 +1,49 @@
+import * as assert from 'assert'
+import * as ts from 'typescript'
+import { convertTsDiagnostic } from '../diagnostics'
+import { DiagnosticTag } from '../request-type'
+
+describe('diagnostics', () => {
+    describe('convertTsDiagnostic()', () => {
+        const sourceFile = ts.createSourceFile('/src/errors.ts', 'let text = 1\ntext = 2', ts.ScriptTarget.ES2015, true)
+        const diagnostic: ts.Diagnostic = {
+            file: sourceFile,
+            start: 13,
+            length: 4,
+            messageText: "'text' is declared but its value is never read.",
+            category: ts.DiagnosticCategory.Error,
+            code: 6133,
+            reportsUnnecessary: {},
+            relatedInformation: [
+                {
+                    file: sourceFile,
+                    start: 4,
+                    length: 4,
+                    messageText: "'text' is declared here.",
+                    category: ts.DiagnosticCategory.Message,
+                    code: 2728,
+                },
+            ],
+        }
+
+        it('should tag unnecessary code', () => {
+            assert.deepEqual(convertTsDiagnostic(diagnostic).tags, [DiagnosticTag.Unnecessary])
+        })
+
+        it('should convert related information if the client supports it', () => {
+            assert.deepEqual(convertTsDiagnostic(diagnostic, 'utf-16', true).relatedInformation, [
+                {
+                    location: {
+                        uri: 'file:///src/errors.ts',
+                        range: { start: { line: 0, character: 4 }, end: { line: 0, character: 8 } },
+                    },
+                    message: "'text' is declared here.",
+                },
+            ])
+        })
+
+        it('should omit related information if the client does not support it', () => {
+            assert.strictEqual(convertTsDiagnostic(diagnostic, 'utf-16', false).relatedInformation, undefined)
+        })
+    })
+})
@@ -72,12 +72,33 @@ describe('fs.ts', () => {
                     .toPromise()
                 assert.sameMembers(files, [rootUri + 'foo/bar.ts'])
             })
+            it('should refuse to list files outside of the root', async () => {
+                await assert.isRejected(
+                    fileSystem
+                        .getWorkspaceFiles(rootUri + '../node_modules')
+                        .toArray()
+                        .toPromise(),
+                    /outside of the workspace root/
+                )
+            })
         })
         describe('getTextDocumentContent()', () => {
             it('should read files denoted by absolute URI', async () => {
                 const content = await fileSystem.getTextDocumentContent(rootUri + 'tweedledee').toPromise()
                 assert.equal(content, 'hi')
             })
+            it('should read files in symlinked directories', async () => {
+                const content = await fileSystem
+                    .getTextDocumentContent(rootUri + 'node_modules/some_package/src/function.ts')
+                    .toPromise()
+                assert.equal(content, 'foo')
+            })
+            it('should read files outside of the root', async () => {
+                const content = await fileSystem
+                    .getTextDocumentContent(rootUri + '../node_modules/some_package/src/function.ts')
+                    .toPromise()
+                assert.equal(content, 'foo')
+            })
         })
     })
 })
@@ -0,0 +1,29 @@
+import * as sinon from 'sinon'
+import { LevelLogger, NoopLogger } from '../logging'
+
+describe('logging', () => {
+    describe('LevelLogger', () => {
+        it('should only pass messages of the minimum level or above to the wrapped logger', () => {
+            const logger = new NoopLogger()
+            const log = sinon.spy(logger, 'log')
+            const info = sinon.spy(logger, 'info')
+            const warn = sinon.spy(logger, 'warn')
+            const error = sinon.spy(logger, 'error')
+            const levelLogger = new LevelLogger(logger, 'warn')
+            levelLogger.log('a')
+            levelLogger.info('b')
+            levelLogger.warn('c')
+            levelLogger.error('d')
+            sinon.assert.notCalled(log)
+            sinon.assert.notCalled(info)
+            sinon.assert.calledWithExactly(warn, 'c')
+            sinon.assert.calledWithExactly(error, 'd')
+        })
+        it('should pass all messages to the wrapped logger at the debug level', () => {
+            const logger = new NoopLogger()
+            const log = sinon.spy(logger, 'log')
+            new LevelLogger(logger, 'debug').log('a', 1)
+            sinon.assert.calledWithExactly(log, 'a', 1)
+        })
+    })
+})
@@ -6,10 +6,13 @@ import { Observable } from 'rxjs'
 import * as sinon from 'sinon'
 import * as ts from 'typescript'
 import {
+    CodeAction,
     CompletionItemKind,
     CompletionList,
     DiagnosticSeverity,
+    FileChangeType,
     InsertTextFormat,
+    ReferenceParams,
     TextDocumentIdentifier,
     TextDocumentItem,
     WorkspaceEdit,
@@ -87,6 +90,7 @@ export const initializeTypeScriptService = (
         )
         this.client.xcacheGet.callsFake(() => Observable.of(null))
         this.client.workspaceApplyEdit.callsFake(() => Observable.of({ applied: true }))
+        this.client.windowWorkDoneProgressCreate.callsFake(() => Observable.of(null))
         this.service = createService(this.client)
 
         await this.service
@@ -933,6 +937,16 @@ export function describeTypeScriptService(
                         },
                     ])
                 })
+                it('should rank exact matches before prefix matches', async function(this: TestContext &
+                    Context): Promise<void> {
+                    const result: SymbolInformation[] = await this.service
+                        .workspaceSymbol({ query: 'b' })
+                        .reduce<Operation, SymbolInformation[]>(applyReducer, null as any)
+                        .toPromise()
+                    assert.isAbove(result.length, 1)
+                    assert.equal(result[0].name, 'b')
+                    assert.equal(result[0].kind, SymbolKind.Class)
+                })
                 it('should return all symbols for an empty query excluding dependencies', async function(this: TestContext &
                     Context): Promise<void> {
                     const result: SymbolInformation[] = await this.service
@@ -1100,6 +1114,14 @@ export function describeTypeScriptService(
                         },
                     ])
                 })
+                it('should return at most as many symbols as the given limit', async function(this: TestContext &
+                    Context): Promise<void> {
+                    const result: SymbolInformation[] = await this.service
+                        .workspaceSymbol({ query: '', limit: 2 })
+                        .reduce<Operation, SymbolInformation[]>(applyReducer, null as any)
+                        .toPromise()
+                    assert.lengthOf(result, 2)
+                })
             })
         })
 
@@ -1468,6 +1490,36 @@ export function describeTypeScriptService(
         })
     })
 
+    describe('Workspace with multiple projects', () => {
+        beforeEach(
+            initializeTypeScriptService(
+                createService,
+                rootUri,
+                new Map([
+                    [rootUri + 'a/tsconfig.json', '{}'],
+                    [rootUri + 'a/a.ts', 'const fooA = 1; const fooB = 2; const fooC = 3'],
+                    [rootUri + 'b/tsconfig.json', '{}'],
+                    [rootUri + 'b/b.ts', 'const foo = 4'],
+                ])
+            )
+        )
+
+        afterEach(shutdownService)
+
+        describe('workspaceSymbol()', () => {
+            it('should rank matches of all projects before applying the limit', async function(this: TestContext &
+                Context): Promise<void> {
+                const result: SymbolInformation[] = await this.service
+                    .workspaceSymbol({ query: 'foo', limit: 2 })
+                    .reduce<Operation, SymbolInformation[]>(applyReducer, null as any)
+                    .toPromise()
+                assert.lengthOf(result, 2)
+                assert.equal(result[0].name, 'foo')
+                assert.equal(result[0].location.uri, rootUri + 'b/b.ts')
+            })
+        })
+    })
+
     describe('Dependency detection', () => {
         beforeEach(
             initializeTypeScriptService(
@@ -1865,6 +1917,57 @@ export function describeTypeScriptService(
             })
         })
 
+        it('should apply didChange received before didOpen finished', async function(this: TestContext &
+            Context): Promise<void> {
+            const didOpen = this.service.textDocumentDidOpen({
+                textDocument: {
+                    uri: rootUri + 'a.ts',
+                    languageId: 'typescript',
+                    version: 1,
+                    text: 'let parameters: string[]',
+                },
+            })
+            const didChange = this.service.textDocumentDidChange({
+                textDocument: {
+                    uri: rootUri + 'a.ts',
+                    version: 2,
+                },
+                contentChanges: [
+                    {
+                        range: { start: { line: 0, character: 16 }, end: { line: 0, character: 22 } },
+                        text: 'number',
+                    },
+                ],
+            })
+            await Promise.all([didOpen, didChange])
+
+            const result: Hover = await this.service
+                .textDocumentHover({
+                    textDocument: {
+                        uri: rootUri + 'a.ts',
+                    },
+                    position: {
+                        line: 0,
+                        character: 5,
+                    },
+                })
+                .reduce<Operation, Hover>(applyReducer, null as any)
+                .toPromise()
+            assert.deepEqual(result, {
+                range: {
+                    end: {
+                        character: 14,
+                        line: 0,
+                    },
+                    start: {
+                        character: 4,
+                        line: 0,
+                    },
+                },
+                contents: [{ language: 'typescript', value: 'let parameters: number[]' }, '**let**'],
+            })
+        })
+
         it('should reflect updated content', async function(this: TestContext & Context): Promise<void> {
             const hoverParams = {
                 textDocument: {
@@ -1960,6 +2063,48 @@ export function describeTypeScriptService(
         })
     })
 
+    describe('Position encodings', () => {
+        beforeEach(
+            initializeTypeScriptService(
+                createService,
+                rootUri,
+                new Map([[rootUri + 'a.ts', 'const ä = "💩"; let parameters = [];']]),
+                { ...DEFAULT_CAPABILITIES, general: { positionEncodings: ['utf-8', 'utf-16'] } }
+            )
+        )
+
+        afterEach(shutdownService)
+
+        it('should count characters in UTF-8 code units if negotiated', async function(this: TestContext &
+            Context): Promise<void> {
+            const result: Hover = await this.service
+                .textDocumentHover({
+                    textDocument: {
+                        uri: rootUri + 'a.ts',
+                    },
+                    position: {
+                        line: 0,
+                        character: 24,
+                    },
+                })
+                .reduce<Operation, Hover>(applyReducer, null as any)
+                .toPromise()
+            assert.deepEqual(result, {
+                range: {
+                    start: {
+                        line: 0,
+                        character: 23,
+                    },
+                    end: {
+                        line: 0,
+                        character: 33,
+                    },
+                },
+                contents: [{ language: 'typescript', value: 'let parameters: any[]' }, '**let**'],
+            })
+        })
+    })
+
     describe('Diagnostics', () => {
         beforeEach(
             initializeTypeScriptService(
@@ -2059,6 +2204,70 @@ export function describeTypeScriptService(
             })
         })
 
+        it('should apply incremental changes on didChange', async function(this: TestContext & Context): Promise<void> {
+            await this.service.textDocumentDidOpen({
+                textDocument: {
+                    uri: rootUri + 'src/errors.ts',
+                    languageId: 'typescript',
+                    text: 'const text: string = 33;',
+                    version: 1,
+                },
+            })
+
+            this.client.textDocumentPublishDiagnostics.resetHistory()
+
+            await this.service.textDocumentDidChange({
+                textDocument: {
+                    uri: rootUri + 'src/errors.ts',
+                    version: 2,
+                },
+                contentChanges: [
+                    {
+                        range: { start: { line: 0, character: 12 }, end: { line: 0, character: 18 } },
+                        text: 'boolean',
+                    },
+                ],
+            })
+
+            sinon.assert.calledOnce(this.client.textDocumentPublishDiagnostics)
+            sinon.assert.calledWithExactly(this.client.textDocumentPublishDiagnostics, {
+                diagnostics: [
+                    {
+                        message: "Type '33' is not assignable to type 'boolean'.",
+                        range: { end: { character: 10, line: 0 }, start: { character: 6, line: 0 } },
+                        severity: 1,
+                        source: 'ts',
+                        code: 2322,
+                    },
+                ],
+                uri: rootUri + 'src/errors.ts',
+            })
+        })
+
+        it('should ignore didChange for an outdated version', async function(this: TestContext &
+            Context): Promise<void> {
+            await this.service.textDocumentDidOpen({
+                textDocument: {
+                    uri: rootUri + 'src/errors.ts',
+                    languageId: 'typescript',
+                    text: 'const text: string = 33;',
+                    version: 2,
+                },
+            })
+
+            this.client.textDocumentPublishDiagnostics.resetHistory()
+
+            await this.service.textDocumentDidChange({
+                textDocument: {
+                    uri: rootUri + 'src/errors.ts',
+                    version: 1,
+                },
+                contentChanges: [{ text: 'const text: number = 33;' }],
+            })
+
+            sinon.assert.notCalled(this.client.textDocumentPublishDiagnostics)
+        })
+
         it('should clear diagnostics on didClose', async function(this: TestContext & Context): Promise<void> {
             await this.service.textDocumentDidClose({
                 textDocument: {
@@ -2479,6 +2688,25 @@ export function describeTypeScriptService(
             ])
         })
 
+        it('should not include the declaration if no context is given', async function(this: TestContext &
+            Context): Promise<void> {
+            // Some clients omit the ReferenceContext even though the protocol requires it
+            const params = {
+                textDocument: {
+                    uri: rootUri + 'a.ts',
+                },
+                position: {
+                    line: 4,
+                    character: 5,
+                },
+            } as ReferenceParams
+            const result = await this.service
+                .textDocumentReferences(params)
+                .reduce<Operation, Location[]>(applyReducer, null as any)
+                .toPromise()
+            assert.deepEqual(result, [])
+        })
+
         it('should provide a reference within the same file', async function(this: TestContext & Context): Promise<
             void
         > {
@@ -3282,6 +3510,8 @@ export function describeTypeScriptService(
                             'const a = new A();',
                         ].join('\n'),
                     ],
+                    [rootUri + 'b.ts', ["import { y, x } from './c'", 'x + y'].join('\n')],
+                    [rootUri + 'c.ts', 'export const x = 1\nexport const y = 2'],
                 ])
             )
         )
@@ -3346,6 +3576,309 @@ export function describeTypeScriptService(
                 },
             ])
         })
+
+        it('organizes imports if requested', async function(this: TestContext & Context): Promise<void> {
+            const actions: Command[] = await this.service
+                .textDocumentCodeAction({
+                    textDocument: {
+                        uri: rootUri + 'b.ts',
+                    },
+                    range: {
+                        start: { line: 0, character: 0 },
+                        end: { line: 0, character: 0 },
+                    },
+                    context: {
+                        diagnostics: [],
+                        only: ['source.organizeImports'],
+                    },
+                })
+                .reduce<Operation, Command[]>(applyReducer, null as any)
+                .toPromise()
+            assert.lengthOf(actions, 1)
+            assert.equal(actions[0].title, 'Organize Imports')
+            assert.equal(actions[0].command, 'codeFix')
+            const fileTextChanges: ts.FileTextChanges[] = actions[0].arguments!
+            assert.lengthOf(fileTextChanges, 1)
+            assert.equal(fileTextChanges[0].fileName, toUnixPath(uri2path(rootUri + 'b.ts')))
+            assert.include(fileTextChanges[0].textChanges.map(change => change.newText).join(''), '{ x, y }')
+        })
+
+        it('does not organize imports if not requested', async function(this: TestContext & Context): Promise<void> {
+            const actions: Command[] = await this.service
+                .textDocumentCodeAction({
+                    textDocument: {
+                        uri: rootUri + 'b.ts',
+                    },
+                    range: {
+                        start: { line: 0, character: 0 },
+                        end: { line: 0, character: 0 },
+                    },
+                    context: {
+                        diagnostics: [],
+                    },
+                })
+                .reduce<Operation, Command[]>(applyReducer, null as any)
+                .toPromise()
+            assert.deepEqual(actions, [])
+        })
+    })
+
+    describe('textDocumentCodeAction() with CodeAction literal support', () => {
+        beforeEach(
+            initializeTypeScriptService(
+                createService,
+                rootUri,
+                new Map([
+                    [rootUri + 'package.json', JSON.stringify({ name: 'mypkg' })],
+                    [
+                        rootUri + 'a.ts',
+                        [
+                            'class A {',
+                            '\tmissingThis: number;',
+                            '\tconstructor() {',
+                            '\t\tmissingThis = 33;',
+                            '\t}',
+                            '}',
+                            'const a = new A();',
+                        ].join('\n'),
+                    ],
+                    [rootUri + 'b.ts', 'const x = 1 + 2'],
+                    [
+                        rootUri + 'c.ts',
+                        [
+                            'class C {',
+                            '\tfoo: number;',
+                            '\tbar: number;',
+                            '\tconstructor() {',
+                            '\t\tfoo = bar;',
+                            '\t}',
+                            '}',
+                        ].join('\n'),
+                    ],
+                ]),
+                {
+                    textDocument: {
+                        codeAction: {
+                            codeActionLiteralSupport: {
+                                codeActionKind: {
+                                    valueSet: ['quickfix', 'source.organizeImports'],
+                                },
+                            },
+                        },
+                    },
+                    ...DEFAULT_CAPABILITIES,
+                }
+            )
+        )
+
+        afterEach(shutdownService)
+
+        const diagnostic: Diagnostic = {
+            range: {
+                start: { line: 3, character: 4 },
+                end: { line: 3, character: 15 },
+            },
+            message: "Cannot find name 'missingThis'. Did you mean the instance member 'this.missingThis'?",
+            severity: DiagnosticSeverity.Error,
+            code: 2663,
+            source: 'ts',
+        }
+
+        it('returns quick fixes as CodeActions with kind quickfix', async function(this: TestContext &
+            Context): Promise<void> {
+            const actions: CodeAction[] = await this.service
+                .textDocumentCodeAction({
+                    textDocument: {
+                        uri: rootUri + 'a.ts',
+                    },
+                    range: diagnostic.range,
+                    context: {
+                        diagnostics: [diagnostic],
+                        only: ['quickfix'],
+                    },
+                })
+                .reduce<Operation, CodeAction[]>(applyReducer, null as any)
+                .toPromise()
+            assert.deepEqual(actions, [
+                {
+                    title: "Add 'this.' to unresolved variable",
+                    kind: 'quickfix',
+                    diagnostics: [diagnostic],
+                    command: {
+                        title: "Add 'this.' to unresolved variable",
+                        command: 'codeFix',
+                        arguments: [
+                            {
+                                fileName: toUnixPath(uri2path(rootUri + 'a.ts')), // path only used by TS service
+                                textChanges: [
+                                    {
+                                        span: { start: 51, length: 11 },
+                                        newText: 'this.missingThis',
+                                    },
+                                ],
+                            },
+                        ],
+                    },
+                },
+            ])
+        })
+
+        it('associates quick fixes only with the diagnostic they resolve', async function(this: TestContext &
+            Context): Promise<void> {
+            const fooDiagnostic: Diagnostic = {
+                range: {
+                    start: { line: 4, character: 2 },
+                    end: { line: 4, character: 5 },
+                },
+                message: "Cannot find name 'foo'. Did you mean the instance member 'this.foo'?",
+                severity: DiagnosticSeverity.Error,
+                code: 2663,
+                source: 'ts',
+            }
+            const barDiagnostic: Diagnostic = {
+                range: {
+                    start: { line: 4, character: 8 },
+                    end: { line: 4, character: 11 },
+                },
+                message: "Cannot find name 'bar'. Did you mean the instance member 'this.bar'?",
+                severity: DiagnosticSeverity.Error,
+                code: 2663,
+                source: 'ts',
+            }
+            const actions: CodeAction[] = await this.service
+                .textDocumentCodeAction({
+                    textDocument: {
+                        uri: rootUri + 'c.ts',
+                    },
+                    range: {
+                        start: { line: 4, character: 0 },
+                        end: { line: 4, character: 12 },
+                    },
+                    context: {
+                        diagnostics: [fooDiagnostic, barDiagnostic],
+                        only: ['quickfix'],
+                    },
+                })
+                .reduce<Operation, CodeAction[]>(applyReducer, null as any)
+                .toPromise()
+            assert.deepEqual(
+                actions.map(action => ({
+                    diagnostics: action.diagnostics,
+                    changes: action.command!.arguments![0].textChanges,
+                })),
+                [
+                    {
+                        diagnostics: [fooDiagnostic],
+                        changes: [{ span: { start: 57, length: 3 }, newText: 'this.foo' }],
+                    },
+                    {
+                        diagnostics: [barDiagnostic],
+                        changes: [{ span: { start: 63, length: 3 }, newText: 'this.bar' }],
+                    },
+                ]
+            )
+        })
+
+        it('does not return quick fixes if other kinds are requested', async function(this: TestContext &
+            Context): Promise<void> {
+            const actions: CodeAction[] = await this.service
+                .textDocumentCodeAction({
+                    textDocument: {
+                        uri: rootUri + 'a.ts',
+                    },
+                    range: diagnostic.range,
+                    context: {
+                        diagnostics: [diagnostic],
+                        only: ['source'],
+                    },
+                })
+                .reduce<Operation, CodeAction[]>(applyReducer, null as any)
+                .toPromise()
+            assert.deepEqual(actions, [])
+        })
+
+        it('returns refactorings that are applied when executed', async function(this: TestContext &
+            Context): Promise<void> {
+            const actions: CodeAction[] = await this.service
+                .textDocumentCodeAction({
+                    textDocument: {
+                        uri: rootUri + 'b.ts',
+                    },
+                    range: {
+                        start: { line: 0, character: 10 },
+                        end: { line: 0, character: 15 },
+                    },
+                    context: {
+                        diagnostics: [],
+                        only: ['refactor.extract'],
+                    },
+                })
+                .reduce<Operation, CodeAction[]>(applyReducer, null as any)
+                .toPromise()
+            assert.isNotEmpty(actions)
+            for (const action of actions) {
+                assert.equal(action.kind, 'refactor.extract')
+                assert.equal(action.command!.command, 'refactor')
+            }
+            const extractConstant = actions.find(action => action.command!.arguments![0].action.startsWith('constant'))
+            assert.isDefined(extractConstant)
+
+            await this.service
+                .workspaceExecuteCommand(extractConstant!.command!)
+                .reduce<Operation, null>(applyReducer, null as any)
+                .toPromise()
+            sinon.assert.calledOnce(this.client.workspaceApplyEdit)
+            const { edit } = this.client.workspaceApplyEdit.lastCall.args[0]
+            const newTexts = edit.changes![rootUri + 'b.ts'].map(textEdit => textEdit.newText)
+            assert.include(newTexts.join(''), 'newLocal')
+        })
+
+        it('does not offer refactorings that create new files', async function(this: TestContext &
+            Context): Promise<void> {
+            const actions: CodeAction[] = await this.service
+                .textDocumentCodeAction({
+                    textDocument: {
+                        uri: rootUri + 'a.ts',
+                    },
+                    range: {
+                        start: { line: 6, character: 0 },
+                        end: { line: 6, character: 18 },
+                    },
+                    context: {
+                        diagnostics: [],
+                        only: ['refactor'],
+                    },
+                })
+                .reduce<Operation, CodeAction[]>(applyReducer, null as any)
+                .toPromise()
+            assert.notInclude(actions.map(action => action.command!.arguments![0].refactor), 'Move to a new file')
+        })
+
+        it('errors when executing a refactoring that creates new files', async function(this: TestContext &
+            Context): Promise<void> {
+            await assert.isRejected(
+                this.service
+                    .workspaceExecuteCommand({
+                        title: 'Move to a new file',
+                        command: 'refactor',
+                        arguments: [
+                            {
+                                textDocument: { uri: rootUri + 'a.ts' },
+                                range: {
+                                    start: { line: 6, character: 0 },
+                                    end: { line: 6, character: 18 },
+                                },
+                                refactor: 'Move to a new file',
+                                action: 'Move to a new file',
+                            },
+                        ],
+                    })
+                    .reduce<Operation, null>(applyReducer, null as any)
+                    .toPromise(),
+                /creates new files/
+            )
+            sinon.assert.notCalled(this.client.workspaceApplyEdit)
+        })
     })
 
     describe('workspaceExecuteCommand()', () => {
@@ -3417,6 +3950,302 @@ export function describeTypeScriptService(
                     },
                 })
             })
+
+            it('should error if the client did not apply the WorkspaceEdit', async function(this: TestContext &
+                Context): Promise<void> {
+                this.client.workspaceApplyEdit.callsFake(() => Observable.of({ applied: false }))
+                await assert.isRejected(
+                    this.service
+                        .workspaceExecuteCommand({
+                            command: 'codeFix',
+                            arguments: [
+                                {
+                                    fileName: uri2path(rootUri + 'a.ts'),
+                                    textChanges: [
+                                        {
+                                            span: { start: 50, length: 15 },
+                                            newText: '\t\tthis.missingThis',
+                                        },
+                                    ],
+                                },
+                            ],
+                        })
+                        .toPromise(),
+                    'Client did not apply the workspace edit'
+                )
+            })
+        })
+    })
+
+    describe('initialize() with only workspace folders', () => {
+        beforeEach(initializeTypeScriptService(createService, rootUri, new Map([[rootUri + 'a.ts', 'const abc = 1']])))
+
+        afterEach(shutdownService)
+
+        it('should use the first workspace folder as root', async function(this: TestContext &
+            Context): Promise<void> {
+            const service = createService(this.client)
+            await service
+                .initialize({
+                    processId: process.pid,
+                    rootUri: null,
+                    capabilities: DEFAULT_CAPABILITIES,
+                    workspaceFolders: [{ uri: rootUri, name: 'test' }],
+                })
+                .toPromise()
+            try {
+                const result: Hover = await service
+                    .textDocumentHover({
+                        textDocument: {
+                            uri: rootUri + 'a.ts',
+                        },
+                        position: {
+                            line: 0,
+                            character: 7,
+                        },
+                    })
+                    .reduce<Operation, Hover>(applyReducer, null as any)
+                    .toPromise()
+                assert.deepEqual(result.contents, [{ language: 'typescript', value: 'const abc: 1' }, '**const**'])
+            } finally {
+                await service.shutdown().toPromise()
+            }
+        })
+    })
+
+    describe('initialize() with work done progress support', () => {
+        beforeEach(
+            initializeTypeScriptService(createService, rootUri, new Map([[rootUri + 'a.ts', 'const abc = 1']]), {
+                window: {
+                    workDoneProgress: true,
+                },
+                ...DEFAULT_CAPABILITIES,
+            })
+        )
+
+        afterEach(shutdownService)
+
+        it('should report progress of fetching the workspace after initialized', async function(this: TestContext &
+            Context): Promise<void> {
+            // The server must not send requests before the initialize result
+            sinon.assert.notCalled(this.client.windowWorkDoneProgressCreate)
+            await this.service.initialized()
+            sinon.assert.calledOnce(this.client.windowWorkDoneProgressCreate)
+            // Fetching happens in the background
+            while (this.client.progress.callCount < 2) {
+                await new Promise<void>(resolve => setTimeout(resolve, 10))
+            }
+            const [{ token }] = this.client.windowWorkDoneProgressCreate.lastCall.args
+            sinon.assert.calledTwice(this.client.progress)
+            assert.deepEqual(this.client.progress.firstCall.args[0], {
+                token,
+                value: { kind: 'begin', title: 'Fetching workspace files' },
+            })
+            assert.deepEqual(this.client.progress.secondCall.args[0], { token, value: { kind: 'end' } })
+        })
+
+        it('should fetch the workspace if the client never creates the token', async function(this: TestContext &
+            Context): Promise<void> {
+            this.client.windowWorkDoneProgressCreate.callsFake(() => Observable.never())
+            await this.service.initialized()
+            // Fetching happens in the background
+            const fetchedFile = sinon.match({ textDocument: { uri: rootUri + 'a.ts' } })
+            while (!this.client.textDocumentXcontent.calledWith(fetchedFile)) {
+                await new Promise<void>(resolve => setTimeout(resolve, 10))
+            }
+            sinon.assert.notCalled(this.client.progress)
+        })
+    })
+
+    describe('initialized()', () => {
+        beforeEach(
+            initializeTypeScriptService(
+                createService,
+                rootUri,
+                new Map([[rootUri + 'a.ts', 'const abc = 1']]),
+                {
+                    workspace: {
+                        didChangeWatchedFiles: {
+                            dynamicRegistration: true,
+                        },
+                        configuration: true,
+                    },
+                    ...DEFAULT_CAPABILITIES,
+                }
+            )
+        )
+
+        beforeEach(function(this: TestContext & Context): void {
+            this.client.clientRegisterCapability.callsFake(() => Observable.of(undefined))
+            this.client.workspaceConfiguration.callsFake(() => Observable.of([{}]))
+        })
+
+        afterEach(shutdownService)
+
+        it('should register file watchers if the client supports it', async function(this: TestContext &
+            Context): Promise<void> {
+            await this.service.initialized()
+            sinon.assert.calledOnce(this.client.clientRegisterCapability)
+            const [registration] = this.client.clientRegisterCapability.lastCall.args[0].registrations
+            assert.equal(registration.method, 'workspace/didChangeWatchedFiles')
+        })
+
+        it('should fetch settings if the client supports workspace/configuration', async function(this: TestContext &
+            Context): Promise<void> {
+            await this.service.initialized()
+            sinon.assert.calledOnce(this.client.workspaceConfiguration)
+            sinon.assert.calledWith(this.client.workspaceConfiguration, { items: [{ scopeUri: rootUri }] })
+        })
+
+        it('should fetch settings even if registering file watchers fails', async function(this: TestContext &
+            Context): Promise<void> {
+            this.client.clientRegisterCapability.callsFake(() => Observable.throw(new Error('Registration rejected')))
+            await this.service.initialized()
+            sinon.assert.calledOnce(this.client.workspaceConfiguration)
+        })
+    })
+
+    describe('workspaceDidChangeWatchedFiles()', () => {
+        const files = new Map<string, string>()
+
+        beforeEach(() => {
+            files.clear()
+            files.set(rootUri + 'a.ts', 'const abc = 1')
+        })
+
+        beforeEach(initializeTypeScriptService(createService, rootUri, files))
+
+        afterEach(shutdownService)
+
+        const hover = (service: TypeScriptService): Promise<Hover> =>
+            service
+                .textDocumentHover({
+                    textDocument: {
+                        uri: rootUri + 'a.ts',
+                    },
+                    position: {
+                        line: 0,
+                        character: 7,
+                    },
+                })
+                .reduce<Operation, Hover>(applyReducer, null as any)
+                .toPromise()
+
+        it('should pick up changed files', async function(this: TestContext & Context): Promise<void> {
+            assert.deepEqual((await hover(this.service)).contents, [
+                { language: 'typescript', value: 'const abc: 1' },
+                '**const**',
+            ])
+            files.set(rootUri + 'a.ts', 'const abc = 2')
+            await this.service.workspaceDidChangeWatchedFiles({
+                changes: [{ uri: rootUri + 'a.ts', type: FileChangeType.Changed }],
+            })
+            assert.deepEqual((await hover(this.service)).contents, [
+                { language: 'typescript', value: 'const abc: 2' },
+                '**const**',
+            ])
+        })
+
+        const symbolNames = async (service: TypeScriptService, query: string): Promise<string[]> => {
+            const symbols = await service
+                .workspaceSymbol({ query })
+                .reduce<Operation, SymbolInformation[]>(applyReducer, null as any)
+                .toPromise()
+            return symbols.map(symbol => symbol.name)
+        }
+
+        it('should pick up created files', async function(this: TestContext & Context): Promise<void> {
+            assert.notInclude(await symbolNames(this.service, 'xyz'), 'xyz')
+            files.set(rootUri + 'b.ts', 'const xyz = 1')
+            await this.service.workspaceDidChangeWatchedFiles({
+                changes: [{ uri: rootUri + 'b.ts', type: FileChangeType.Created }],
+            })
+            assert.include(await symbolNames(this.service, 'xyz'), 'xyz')
+        })
+
+        it('should remove deleted files', async function(this: TestContext & Context): Promise<void> {
+            assert.include(await symbolNames(this.service, 'abc'), 'abc')
+            files.delete(rootUri + 'a.ts')
+            await this.service.workspaceDidChangeWatchedFiles({
+                changes: [{ uri: rootUri + 'a.ts', type: FileChangeType.Deleted }],
+            })
+            assert.notInclude(await symbolNames(this.service, 'abc'), 'abc')
+        })
+
+        it('should use the fallback config after deleting the only tsconfig.json', async function(this: TestContext &
+            Context): Promise<void> {
+            files.set(rootUri + 'tsconfig.json', '{}')
+            await this.service.workspaceDidChangeWatchedFiles({
+                changes: [{ uri: rootUri + 'tsconfig.json', type: FileChangeType.Created }],
+            })
+            assert.deepEqual((await hover(this.service)).contents, [
+                { language: 'typescript', value: 'const abc: 1' },
+                '**const**',
+            ])
+            files.delete(rootUri + 'tsconfig.json')
+            await this.service.workspaceDidChangeWatchedFiles({
+                changes: [{ uri: rootUri + 'tsconfig.json', type: FileChangeType.Deleted }],
+            })
+            assert.deepEqual((await hover(this.service)).contents, [
+                { language: 'typescript', value: 'const abc: 1' },
+                '**const**',
+            ])
+        })
+
+        it('should ignore changes to open documents', async function(this: TestContext & Context): Promise<void> {
+            await this.service.textDocumentDidOpen({
+                textDocument: {
+                    uri: rootUri + 'a.ts',
+                    languageId: 'typescript',
+                    text: 'const abc = 3',
+                    version: 1,
+                },
+            })
+            files.set(rootUri + 'a.ts', 'const abc = 2')
+            await this.service.workspaceDidChangeWatchedFiles({
+                changes: [{ uri: rootUri + 'a.ts', type: FileChangeType.Changed }],
+            })
+            assert.deepEqual((await hover(this.service)).contents, [
+                { language: 'typescript', value: 'const abc: 3' },
+                '**const**',
+            ])
+        })
+    })
+
+    describe('workspaceWillRenameFiles()', () => {
+        beforeEach(
+            initializeTypeScriptService(
+                createService,
+                rootUri,
+                new Map([
+                    [rootUri + 'a.ts', 'export const x = 1'],
+                    [rootUri + 'b.ts', ["import { x } from './a'", 'x'].join('\n')],
+                ])
+            )
+        )
+
+        afterEach(shutdownService)
+
+        it('should update imports of a renamed file', async function(this: TestContext & Context): Promise<void> {
+            const result: WorkspaceEdit = await this.service
+                .workspaceWillRenameFiles({
+                    files: [{ oldUri: rootUri + 'a.ts', newUri: rootUri + 'c.ts' }],
+                })
+                .reduce<Operation, WorkspaceEdit>(applyReducer, null as any)
+                .toPromise()
+            assert.deepEqual(result, {
+                changes: {
+                    [rootUri + 'b.ts']: [
+                        {
+                            range: {
+                                start: { line: 0, character: 19 },
+                                end: { line: 0, character: 22 },
+                            },
+                            newText: './c',
+                        },
+                    ],
+                },
+            })
         })
     })
 
@@ -1,11 +1,16 @@
 import * as assert from 'assert'
 import {
+    applyContentChange,
+    escapeSnippetText,
+    fromUtf16Character,
     getMatchingPropertyCount,
     getPropertyCount,
     isGlobalTSFile,
     isSymbolDescriptorMatch,
     JSONPTR,
+    normalizeUri,
     path2uri,
+    toUtf16Character,
     uri2path,
 } from '../util'
 
@@ -155,6 +160,18 @@ describe('util', () => {
             assert.equal(isGlobalTSFile('/node_modules/@types/mocha/index.d.ts'), true)
         })
     })
+    describe('normalizeUri()', () => {
+        it('should encode special characters in the path', () => {
+            assert.equal(normalizeUri('file:///baz/@qux'), 'file:///baz/%40qux')
+        })
+        it('should preserve the case of the host', () => {
+            assert.equal(normalizeUri('file://Server/Share/a.ts'), 'file://Server/Share/a.ts')
+        })
+        it('should match path2uri() for UNC paths', () => {
+            const uri = path2uri('\\\\Server\\Share\\a.ts')
+            assert.equal(normalizeUri(uri), uri)
+        })
+    })
     describe('path2uri()', () => {
         it('should throw an error if a non-absolute uri is passed in', () => {
             assert.throws(() => path2uri('baz/qux'))
@@ -175,6 +192,10 @@ describe('util', () => {
             const uri = path2uri('/@baz')
             assert.equal(uri, 'file:///%40baz')
         })
+        it('should convert a Windows UNC path to a URI with authority', () => {
+            const uri = path2uri('\\\\server\\share\\baz qux')
+            assert.equal(uri, 'file://server/share/baz%20qux')
+        })
     })
     describe('uri2path()', () => {
         it('should convert a Unix file URI to a file path', () => {
@@ -197,5 +218,85 @@ describe('util', () => {
             const filePath = uri2path('file:///%40foo')
             assert.equal(filePath, '/@foo')
         })
+        it('should convert a file URI with authority to a Windows UNC path', () => {
+            const filePath = uri2path('file://server/share/baz%20qux')
+            assert.equal(filePath, '\\\\server\\share\\baz qux')
+        })
+        it('should ignore a localhost authority', () => {
+            const filePath = uri2path('file://localhost/baz/qux')
+            assert.equal(filePath, '/baz/qux')
+        })
+        it('should round-trip paths through path2uri()', () => {
+            for (const filePath of [
+                '/baz/qux',
+                'C:\\baz\\qux',
+                '\\\\server\\share\\qux',
+                '\\\\Server\\Share\\qux',
+                '//baz/qux',
+                '/💩/@baz',
+            ]) {
+                assert.equal(uri2path(path2uri(filePath)), filePath)
+            }
+        })
+    })
+    describe('applyContentChange()', () => {
+        const text = 'let a = 1\r\nlet 💩 = 2\n'
+        it('should replace the whole text if the change has no range', () => {
+            assert.equal(applyContentChange(text, { text: 'foo' }), 'foo')
+        })
+        it('should replace the range of the change', () => {
+            const range = { start: { line: 1, character: 9 }, end: { line: 1, character: 10 } }
+            assert.equal(applyContentChange(text, { range, text: '3' }), 'let a = 1\r\nlet 💩 = 3\n')
+        })
+        it('should count the characters of the range in the given encoding', () => {
+            const range = { start: { line: 1, character: 4 }, end: { line: 1, character: 8 } }
+            assert.equal(applyContentChange(text, { range, text: 'b' }, 'utf-8'), 'let a = 1\r\nlet b = 2\n')
+        })
+        it('should insert at the end of the text for positions past the end', () => {
+            const range = { start: { line: 5, character: 0 }, end: { line: 5, character: 0 } }
+            assert.equal(applyContentChange(text, { range, text: 'x' }), text + 'x')
+        })
+        it('should not insert into a CRLF line break for characters past the end of a line', () => {
+            const range = { start: { line: 0, character: 20 }, end: { line: 0, character: 20 } }
+            assert.equal(applyContentChange(text, { range, text: ';' }), 'let a = 1;\r\nlet 💩 = 2\n')
+        })
+    })
+    describe('escapeSnippetText()', () => {
+        it('should escape dollar signs, closing braces and backslashes', () => {
+            assert.equal(escapeSnippetText('$scope}\\'), '\\$scope\\}\\\\')
+        })
+        it('should not change text without special characters', () => {
+            assert.equal(escapeSnippetText('foo_bar'), 'foo_bar')
+        })
+    })
+    describe('fromUtf16Character()', () => {
+        const line = 'const ä = "💩"; let x'
+        it('should return the same offset for utf-16', () => {
+            assert.equal(fromUtf16Character(line, 16, 'utf-16'), 16)
+        })
+        it('should count multi-byte characters and surrogate pairs as bytes for utf-8', () => {
+            assert.equal(fromUtf16Character(line, 16, 'utf-8'), 19)
+        })
+        it('should count surrogate pairs as one code point for utf-32', () => {
+            assert.equal(fromUtf16Character(line, 16, 'utf-32'), 15)
+        })
+        it('should keep offsets past the end of the line', () => {
+            assert.equal(fromUtf16Character('ä', 3, 'utf-8'), 4)
+        })
+    })
+    describe('toUtf16Character()', () => {
+        const line = 'const ä = "💩"; let x'
+        it('should return the same column for utf-16', () => {
+            assert.equal(toUtf16Character(line, 16, 'utf-16'), 16)
+        })
+        it('should convert a utf-8 byte column', () => {
+            assert.equal(toUtf16Character(line, 19, 'utf-8'), 16)
+        })
+        it('should convert a utf-32 code point column', () => {
+            assert.equal(toUtf16Character(line, 15, 'utf-32'), 16)
+        })
+        it('should keep columns past the end of the line', () => {
+            assert.equal(toUtf16Character('ä', 4, 'utf-8'), 3)
+        })
     })
 })
@@ -1,6 +1,6 @@
 import { Operation } from 'fast-json-patch'
 import iterate from 'iterare'
-import { castArray, merge, omit } from 'lodash'
+import { castArray, merge, omit, sortBy } from 'lodash'
 import { toPairs } from 'lodash'
 import hashObject = require('object-hash')
 import { Span } from 'opentracing'
@@ -8,17 +8,22 @@ import { Observable } from 'rxjs'
 import * as ts from 'typescript'
 import * as url from 'url'
 import {
+    CodeAction,
+    CodeActionKind,
     CodeActionParams,
     Command,
     CompletionItemKind,
     CompletionList,
+    Diagnostic,
     DidChangeConfigurationParams,
     DidChangeTextDocumentParams,
+    DidChangeWatchedFilesParams,
     DidCloseTextDocumentParams,
     DidOpenTextDocumentParams,
     DidSaveTextDocumentParams,
     DocumentSymbolParams,
     ExecuteCommandParams,
+    FileChangeType,
     Hover,
     InsertTextFormat,
     Location,
@@ -56,7 +61,10 @@ import {
     PackageDescriptor,
     PackageInformation,
     PluginSettings,
+    PositionEncodingKind,
+    RefactorCommandArguments,
     ReferenceInformation,
+    RenameFilesParams,
     SymbolDescriptor,
     SymbolLocationInformation,
     WorkspaceReferenceParams,
@@ -73,12 +81,16 @@ import {
 } from './symbols'
 import { traceObservable } from './tracing'
 import {
+    applyContentChange,
+    escapeSnippetText,
     getMatchingPropertyCount,
     getPropertyCount,
     JSONPTR,
     normalizeUri,
     observableFromIterable,
+    offsetToPosition,
     path2uri,
+    positionToOffset,
     toUnixPath,
     uri2path,
 } from './util'
@@ -120,6 +132,40 @@ const completionKinds = new Map<string, CompletionItemKind>([
     [`variable`, CompletionItemKind.Variable],
 ])
 
+/**
+ * Maps the ways a NavigateToItem can match a text query to a workspace/symbol score, from best to worst match
+ */
+const NAVIGATE_TO_MATCH_KIND_SCORES = new Map<string, number>([
+    ['exact', 4],
+    ['prefix', 3],
+    ['substring', 2],
+    ['camelCase', 1],
+])
+
+/**
+ * Position encodings the server can convert to, in order of preference
+ */
+const SUPPORTED_POSITION_ENCODINGS: PositionEncodingKind[] = ['utf-16', 'utf-8', 'utf-32']
+
+/**
+ * Returns true if code actions of the given kind should be returned for a `CodeActionContext.only` filter.
+ * Kinds are hierarchical, e.g. requesting `source` includes `source.organizeImports`.
+ * Not passing a filter requests all kinds.
+ */
+function isCodeActionKindRequested(only: string[] | undefined, kind: string): boolean {
+    return !only || only.some(requested => kind === requested || kind.startsWith(requested + '.'))
+}
+
+/**
+ * Returns the CodeActionKind for a TypeScript refactoring
+ */
+function getRefactorKind(refactorName: string): string {
+    if (refactorName.startsWith('Extract')) {
+        return CodeActionKind.RefactorExtract
+    }
+    return CodeActionKind.Refactor
+}
+
 /**
  * Handles incoming requests and return responses. There is a one-to-one-to-one
  * correspondence between TCP connection, TypeScriptService instance, and
@@ -175,6 +221,12 @@ export class TypeScriptService {
      */
     protected isDefinitelyTyped: Observable<boolean>
 
+    /**
+     * Completes when the files of the workspace have been pre-fetched after `initialize`.
+     * Replays the completion or error to late subscribers, e.g. to report progress on it.
+     */
+    protected workspacePrefetch: Observable<never> = Observable.empty<never>()
+
     /**
      * Keeps track of package.jsons in the workspace
      */
@@ -211,6 +263,47 @@ export class TypeScriptService {
      */
     private supportsCompletionWithSnippets = false
 
+    /**
+     * Indicates if the client accepts related information on published diagnostics
+     */
+    private supportsDiagnosticRelatedInformation = false
+
+    /**
+     * Indicates if the client accepts `CodeAction` literals as code action results, as opposed to only `Command`s.
+     */
+    private supportsCodeActionLiterals = false
+
+    /**
+     * Indicates if the client allows the server to register file watchers for workspace/didChangeWatchedFiles
+     */
+    private supportsDidChangeWatchedFilesRegistration = false
+
+    /**
+     * Indicates if the client supports the workspace/configuration request
+     */
+    private supportsWorkspaceConfiguration = false
+
+    /**
+     * Indicates if the client supports server initiated work done progress
+     */
+    private supportsWorkDoneProgress = false
+
+    /**
+     * The last token created for server initiated work done progress
+     */
+    private lastWorkDoneProgressToken = 0
+
+    /**
+     * The encoding the characters of positions in requests and responses are counted in, as negotiated in `initialize`
+     */
+    protected positionEncoding: PositionEncodingKind = 'utf-16'
+
+    /**
+     * URI -> version of the documents opened by the client, as passed in didOpen/didChange.
+     * Used to drop changes that arrive for outdated versions.
+     */
+    protected documentVersions = new Map<string, number>()
+
     constructor(protected client: LanguageClient, protected options: TypeScriptServiceOptions = {}) {
         this.logger = new LSPLogger(client)
     }
@@ -235,10 +328,28 @@ export class TypeScriptService {
      * @return Observable of JSON Patches that build an `InitializeResult`
      */
     public initialize(params: InitializeParams, span = new Span()): Observable<Operation> {
+        // Use the first position encoding supported by both sides, falling back to the mandatory UTF-16
+        const clientPositionEncodings =
+            (params.capabilities.general && params.capabilities.general.positionEncodings) || []
+        this.positionEncoding =
+            clientPositionEncodings.find(encoding => SUPPORTED_POSITION_ENCODINGS.includes(encoding)) || 'utf-16'
+
+        // Clients that only support multi-root workspaces may send workspace folders only, use the first one as root
+        const workspaceFolders = params.workspaceFolders || []
+        if (workspaceFolders.length > 1) {
+            this.logger.warn(
+                'Multiple workspace folders are not supported, ignoring',
+                workspaceFolders.slice(1).map(folder => folder.uri)
+            )
+        }
         // tslint:disable:deprecation
-        if (params.rootUri || params.rootPath) {
-            this.root = params.rootPath || uri2path(params.rootUri!)
-            this.rootUri = params.rootUri || path2uri(params.rootPath!)
+        let rootUri = params.rootUri
+        if (!rootUri && !params.rootPath && workspaceFolders.length > 0) {
+            rootUri = workspaceFolders[0].uri
+        }
+        if (rootUri || params.rootPath) {
+            this.root = params.rootPath || uri2path(rootUri!)
+            this.rootUri = rootUri || path2uri(params.rootPath!)
             // tslint:enable:deprecation
 
             this.supportsCompletionWithSnippets =
@@ -248,6 +359,32 @@ export class TypeScriptService {
                     params.capabilities.textDocument.completion.completionItem.snippetSupport) ||
                 false
 
+            this.supportsDiagnosticRelatedInformation = !!(
+                params.capabilities.textDocument &&
+                params.capabilities.textDocument.publishDiagnostics &&
+                params.capabilities.textDocument.publishDiagnostics.relatedInformation
+            )
+
+            this.supportsDidChangeWatchedFilesRegistration = !!(
+                params.capabilities.workspace &&
+                params.capabilities.workspace.didChangeWatchedFiles &&
+                params.capabilities.workspace.didChangeWatchedFiles.dynamicRegistration
+            )
+
+            this.supportsWorkspaceConfiguration = !!(
+                params.capabilities.workspace && params.capabilities.workspace.configuration
+            )
+
+            this.supportsWorkDoneProgress = !!(
+                params.capabilities.window && params.capabilities.window.workDoneProgress
+            )
+
+            this.supportsCodeActionLiterals = !!(
+                params.capabilities.textDocument &&
+                params.capabilities.textDocument.codeAction &&
+                params.capabilities.textDocument.codeAction.codeActionLiteralSupport
+            )
+
             // The root URI always refers to a directory
             if (!this.rootUri.endsWith('/')) {
                 this.rootUri += '/'
@@ -277,21 +414,28 @@ export class TypeScriptService {
                 .refCount()
 
             // Pre-fetch files in the background if not DefinitelyTyped
-            this.isDefinitelyTyped
+            this.workspacePrefetch = this.isDefinitelyTyped
                 .mergeMap(isDefinitelyTyped => {
                     if (!isDefinitelyTyped) {
                         return this.projectManager.ensureOwnFiles(span)
                     }
                     return []
                 })
-                .subscribe(undefined, err => {
-                    this.logger.error(err)
-                })
+                .publishReplay()
+                .refCount() as Observable<never>
+            this.workspacePrefetch.subscribe(undefined, err => {
+                this.logger.error(err)
+            })
         }
         const result: InitializeResult = {
             capabilities: {
-                // Tell the client that the server works in FULL text document sync mode
-                textDocumentSync: TextDocumentSyncKind.Full,
+                // Tell the client that the server accepts incremental changes of opened documents
+                // and wants to be notified when they are saved (the content is already known)
+                textDocumentSync: {
+                    openClose: true,
+                    change: TextDocumentSyncKind.Incremental,
+                    save: { includeText: false },
+                },
                 hoverProvider: true,
                 signatureHelpProvider: {
                     triggerCharacters: ['(', ','],
@@ -308,12 +452,27 @@ export class TypeScriptService {
                     resolveProvider: true,
                     triggerCharacters: ['.'],
                 },
-                codeActionProvider: true,
+                codeActionProvider: {
+                    codeActionKinds: [
+                        CodeActionKind.QuickFix,
+                        CodeActionKind.Refactor,
+                        CodeActionKind.RefactorExtract,
+                        CodeActionKind.SourceOrganizeImports,
+                    ],
+                },
                 renameProvider: true,
                 executeCommandProvider: {
                     commands: [],
                 },
                 xpackagesProvider: true,
+                positionEncoding: this.positionEncoding,
+                workspace: {
+                    fileOperations: {
+                        willRename: {
+                            filters: [{ scheme: 'file', pattern: { glob: '**/*.{ts,tsx,js,jsx}', matches: 'file' } }],
+                        },
+                    },
+                },
             },
         }
         return Observable.of({
@@ -404,11 +563,7 @@ export class TypeScriptService {
                     throw new Error(`Expected source file ${fileName} to exist`)
                 }
 
-                const offset: number = ts.getPositionOfLineAndCharacter(
-                    sourceFile,
-                    params.position.line,
-                    params.position.character
-                )
+                const offset: number = positionToOffset(sourceFile, params.position, this.positionEncoding)
                 const definitions: ts.DefinitionInfo[] | undefined = goToType
                     ? configuration.getService().getTypeDefinitionAtPosition(fileName, offset)
                     : configuration.getService().getDefinitionAtPosition(fileName, offset)
@@ -421,10 +576,11 @@ export class TypeScriptService {
                                 'expected source file "' + definition.fileName + '" to exist in configuration'
                             )
                         }
-                        const start = ts.getLineAndCharacterOfPosition(sourceFile, definition.textSpan.start)
-                        const end = ts.getLineAndCharacterOfPosition(
+                        const start = offsetToPosition(sourceFile, definition.textSpan.start, this.positionEncoding)
+                        const end = offsetToPosition(
                             sourceFile,
-                            definition.textSpan.start + definition.textSpan.length
+                            definition.textSpan.start + definition.textSpan.length,
+                            this.positionEncoding
                         )
                         return {
                             uri: locationUri(definition.fileName),
@@ -480,11 +636,7 @@ export class TypeScriptService {
                     throw new Error(`Unknown text document ${uri}`)
                 }
                 // Convert line/character to offset
-                const offset: number = ts.getPositionOfLineAndCharacter(
-                    sourceFile,
-                    params.position.line,
-                    params.position.character
-                )
+                const offset: number = positionToOffset(sourceFile, params.position, this.positionEncoding)
                 // Query TypeScript for references
                 return Observable.from(
                     configuration.getService().getDefinitionAtPosition(fileName, offset) || []
@@ -511,13 +663,15 @@ export class TypeScriptService {
                                         location: {
                                             uri: definitionUri,
                                             range: {
-                                                start: ts.getLineAndCharacterOfPosition(
+                                                start: offsetToPosition(
                                                     sourceFile,
-                                                    definition.textSpan.start
+                                                    definition.textSpan.start,
+                                                    this.positionEncoding
                                                 ),
-                                                end: ts.getLineAndCharacterOfPosition(
+                                                end: offsetToPosition(
                                                     sourceFile,
-                                                    definition.textSpan.start + definition.textSpan.length
+                                                    definition.textSpan.start + definition.textSpan.length,
+                                                    this.positionEncoding
                                                 ),
                                             },
                                         },
@@ -633,11 +787,7 @@ export class TypeScriptService {
                     if (!sourceFile) {
                         throw new Error(`Unknown text document ${uri}`)
                     }
-                    const offset: number = ts.getPositionOfLineAndCharacter(
-                        sourceFile,
-                        params.position.line,
-                        params.position.character
-                    )
+                    const offset: number = positionToOffset(sourceFile, params.position, this.positionEncoding)
                     const info = configuration.getService().getQuickInfoAtPosition(fileName, offset)
                     if (!info) {
                         return { contents: [] }
@@ -679,8 +829,12 @@ export class TypeScriptService {
                     if (documentation) {
                         contents.push(documentation)
                     }
-                    const start = ts.getLineAndCharacterOfPosition(sourceFile, info.textSpan.start)
-                    const end = ts.getLineAndCharacterOfPosition(sourceFile, info.textSpan.start + info.textSpan.length)
+                    const start = offsetToPosition(sourceFile, info.textSpan.start, this.positionEncoding)
+                    const end = offsetToPosition(
+                        sourceFile,
+                        info.textSpan.start + info.textSpan.length,
+                        this.positionEncoding
+                    )
 
                     return {
                         contents,
@@ -726,11 +880,7 @@ export class TypeScriptService {
                             throw new Error(`Source file ${fileName} does not exist`)
                         }
                         // Convert line/character to offset
-                        const offset: number = ts.getPositionOfLineAndCharacter(
-                            sourceFile,
-                            params.position.line,
-                            params.position.character
-                        )
+                        const offset: number = positionToOffset(sourceFile, params.position, this.positionEncoding)
                         // Request references at position from TypeScript
                         // Despite the signature, getReferencesAtPosition() can return undefined
                         return Observable.from(
@@ -751,10 +901,15 @@ export class TypeScriptService {
                                         throw new Error(`Source file ${reference.fileName} does not exist`)
                                     }
                                     // Convert offset to line/character position
-                                    const start = ts.getLineAndCharacterOfPosition(sourceFile, reference.textSpan.start)
-                                    const end = ts.getLineAndCharacterOfPosition(
+                                    const start = offsetToPosition(
                                         sourceFile,
-                                        reference.textSpan.start + reference.textSpan.length
+                                        reference.textSpan.start,
+                                        this.positionEncoding
+                                    )
+                                    const end = offsetToPosition(
+                                        sourceFile,
+                                        reference.textSpan.start + reference.textSpan.length,
+                                        this.positionEncoding
                                     )
                                     return {
                                         uri: path2uri(reference.fileName),
@@ -782,14 +937,14 @@ export class TypeScriptService {
      */
     public workspaceSymbol(params: WorkspaceSymbolParams, span = new Span()): Observable<Operation> {
         // Return cached result for empty query, if available
-        if (!params.query && !params.symbol && this.emptyQueryWorkspaceSymbols) {
+        if (!params.query && !params.symbol && !params.limit && this.emptyQueryWorkspaceSymbols) {
             return this.emptyQueryWorkspaceSymbols
         }
 
         /** A sorted array that keeps track of symbol match scores to determine the index to insert the symbol at */
         const scores: number[] = []
 
-        let observable = this.isDefinitelyTyped
+        let symbols = this.isDefinitelyTyped
             .mergeMap(
                 (isDefinitelyTyped: boolean): Observable<[number, SymbolInformation]> => {
                     // Use special logic for DefinitelyTyped
@@ -879,9 +1034,18 @@ export class TypeScriptService {
             // There may be few configurations that contain the same file(s)
             // or files from different configurations may refer to the same file(s)
             .distinct(symbol => hashObject(symbol, { respectType: false } as any))
-            // Limit the total amount of symbols returned for text or empty queries
-            // Higher limit for programmatic symbol queries because it could exclude results with a higher score
-            .take(params.symbol ? 1000 : 100)
+
+        if (params.query) {
+            // Text query results are scored by match quality, which is only known after all projects were searched.
+            // Rank all of them before applying the limit, so the best matches are not excluded
+            symbols = symbols.toArray().mergeMap(results => sortBy(results, ([score]) => -score))
+        }
+
+        let observable = symbols
+            // Limit the total amount of symbols returned to the limit requested by the client, if any
+            // Otherwise use a higher limit for programmatic symbol queries than for text or empty queries
+            // because it could exclude results with a higher score
+            .take(params.limit || (params.symbol ? 1000 : 100))
             // Find out at which index to insert the symbol to maintain sorting order by score
             .map(([score, symbol]) => {
                 const index = scores.findIndex(s => s < score)
@@ -894,7 +1058,7 @@ export class TypeScriptService {
             })
             .startWith({ op: 'add', path: '', value: [] })
 
-        if (!params.query && !params.symbol) {
+        if (!params.query && !params.symbol && !params.limit) {
             observable = this.emptyQueryWorkspaceSymbols = observable.publishReplay().refCount()
         }
 
@@ -926,7 +1090,9 @@ export class TypeScriptService {
                 const tree = config.getService().getNavigationTree(fileName)
                 return observableFromIterable(walkNavigationTree(tree))
                     .filter(({ tree, parent }) => navigationTreeIsSymbol(tree))
-                    .map(({ tree, parent }) => navigationTreeToSymbolInformation(tree, parent, sourceFile, this.root))
+                    .map(({ tree, parent }) =>
+                        navigationTreeToSymbolInformation(tree, parent, sourceFile, this.root, this.positionEncoding)
+                    )
             })
             .map(symbol => ({ op: 'add', path: '/-', value: symbol } as Operation))
             .startWith({ op: 'add', path: '', value: [] } as Operation)
@@ -1034,8 +1200,16 @@ export class TypeScriptService {
                                                     reference: {
                                                         uri: locationUri(source.fileName),
                                                         range: {
-                                                            start: ts.getLineAndCharacterOfPosition(source, node.pos),
-                                                            end: ts.getLineAndCharacterOfPosition(source, node.end),
+                                                            start: offsetToPosition(
+                                                                source,
+                                                                node.pos,
+                                                                this.positionEncoding
+                                                            ),
+                                                            end: offsetToPosition(
+                                                                source,
+                                                                node.end,
+                                                                this.positionEncoding
+                                                            ),
                                                         },
                                                     },
                                                 })
@@ -1061,6 +1235,11 @@ export class TypeScriptService {
                         )
                 )
             })
+            // Filter duplicate references
+            // Files can belong to multiple configurations and would be searched once per configuration
+            .distinct(reference => hashObject(reference, { respectType: false } as any))
+            // Stop searching once the limit requested by the client is reached
+            .take(params.limit || Infinity)
             .map((reference): Operation => ({ op: 'add', path: '/-', value: reference }))
             .startWith({ op: 'add', path: '', value: [] })
     }
@@ -1233,11 +1412,7 @@ export class TypeScriptService {
                     return []
                 }
 
-                const offset: number = ts.getPositionOfLineAndCharacter(
-                    sourceFile,
-                    params.position.line,
-                    params.position.character
-                )
+                const offset: number = positionToOffset(sourceFile, params.position, this.positionEncoding)
                 const completions = configuration.getService().getCompletionsAtPosition(fileName, offset, undefined)
 
                 if (!completions) {
@@ -1303,9 +1478,9 @@ export class TypeScriptService {
                         const parameters = details.displayParts
                             .filter(p => p.kind === 'parameterName')
                             // tslint:disable-next-line:no-invalid-template-strings
-                            .map((p, i) => '${' + `${i + 1}:${p.text}` + '}')
+                            .map((p, i) => '${' + `${i + 1}:${escapeSnippetText(p.text)}` + '}')
                         const paramString = parameters.join(', ')
-                        item.insertText = details.name + `(${paramString})`
+                        item.insertText = escapeSnippetText(details.name) + `(${paramString})`
                         item.insertTextFormat = InsertTextFormat.Snippet
                     } else {
                         item.insertTextFormat = InsertTextFormat.PlainText
@@ -1341,11 +1516,7 @@ export class TypeScriptService {
                     if (!sourceFile) {
                         throw new Error(`expected source file ${filePath} to exist in configuration`)
                     }
-                    const offset: number = ts.getPositionOfLineAndCharacter(
-                        sourceFile,
-                        params.position.line,
-                        params.position.character
-                    )
+                    const offset: number = positionToOffset(sourceFile, params.position, this.positionEncoding)
 
                     const signatures:
                         | ts.SignatureHelpItems
@@ -1388,7 +1559,11 @@ export class TypeScriptService {
      * text document and range. These commands are typically code fixes to either fix problems or to
      * beautify/refactor code.
      *
-     * @return Observable of JSON Patches that build a `Command[]` result
+     * If the client supports `CodeAction` literals, the result contains `CodeAction`s carrying their kind
+     * (e.g. `quickfix`) and the diagnostics they resolve, so the client can filter and group them.
+     * Refactorings are only offered to those clients, as they would be indistinguishable from quick fixes otherwise.
+     *
+     * @return Observable of JSON Patches that build a `(Command | CodeAction)[]` result
      */
     public textDocumentCodeAction(params: CodeActionParams, span = new Span()): Observable<Operation> {
         const uri = normalizeUri(params.textDocument.uri)
@@ -1408,39 +1583,96 @@ export class TypeScriptService {
                     throw new Error(`Expected source file ${filePath} to exist in configuration`)
                 }
 
-                const start = ts.getPositionOfLineAndCharacter(
-                    sourceFile,
-                    params.range.start.line,
-                    params.range.start.character
-                )
-                const end = ts.getPositionOfLineAndCharacter(
-                    sourceFile,
-                    params.range.end.line,
-                    params.range.end.character
-                )
+                const start = positionToOffset(sourceFile, params.range.start, this.positionEncoding)
+                const end = positionToOffset(sourceFile, params.range.end, this.positionEncoding)
+
+                const service = configuration.getService()
+                const actions: (Command | CodeAction)[] = []
+                const addAction = (
+                    title: string,
+                    kind: string,
+                    changes: ts.FileTextChanges[],
+                    diagnostics?: Diagnostic[]
+                ): void => {
+                    const command: Command = { title, command: 'codeFix', arguments: changes }
+                    actions.push(this.supportsCodeActionLiterals ? { title, kind, diagnostics, command } : command)
+                }
 
-                const errorCodes = iterate(params.context.diagnostics)
-                    .map(diagnostic => diagnostic.code)
-                    .filter(code => typeof code === 'number')
-                    .toArray() as number[]
+                if (isCodeActionKindRequested(params.context.only, CodeActionKind.QuickFix)) {
+                    const formatOptions = this.settings.format || {}
+                    // Get fixes per diagnostic, so every fix is only associated with the diagnostic it resolves
+                    for (const diagnostic of params.context.diagnostics) {
+                        if (typeof diagnostic.code !== 'number') {
+                            continue
+                        }
+                        const fixes =
+                            service.getCodeFixesAtPosition(
+                                filePath,
+                                positionToOffset(sourceFile, diagnostic.range.start, this.positionEncoding),
+                                positionToOffset(sourceFile, diagnostic.range.end, this.positionEncoding),
+                                [diagnostic.code],
+                                formatOptions,
+                                {}
+                            ) || []
+                        for (const fix of fixes) {
+                            addAction(fix.description, CodeActionKind.QuickFix, fix.changes, [diagnostic])
+                        }
+                    }
+                }
 
-                return (
-                    configuration
-                        .getService()
-                        .getCodeFixesAtPosition(filePath, start, end, errorCodes, this.settings.format || {}, {}) || []
-                )
+                // Organizing imports affects the whole file, so only offer it when explicitly asked for
+                if (
+                    params.context.only &&
+                    isCodeActionKindRequested(params.context.only, CodeActionKind.SourceOrganizeImports)
+                ) {
+                    const changes = service.organizeImports(
+                        { type: 'file', fileName: filePath },
+                        this.settings.format || {},
+                        undefined
+                    )
+                    if (changes.some(change => change.textChanges.length > 0)) {
+                        addAction('Organize Imports', CodeActionKind.SourceOrganizeImports, changes.slice())
+                    }
+                }
+
+                // Refactoring edits are only computed when the refactor command is executed
+                // Refactorings that create files (e.g. moving statements to a new file) are not offered,
+                // because their edits can't be expressed as text edits of existing documents
+                if (this.supportsCodeActionLiterals) {
+                    const refactors =
+                        service.getApplicableRefactors(
+                            filePath,
+                            { pos: start, end },
+                            { allowTextChangesInNewFiles: false }
+                        ) || []
+                    for (const refactor of refactors) {
+                        const kind = getRefactorKind(refactor.name)
+                        if (!isCodeActionKindRequested(params.context.only, kind)) {
+                            continue
+                        }
+                        for (const action of refactor.actions) {
+                            const refactorArguments: RefactorCommandArguments = {
+                                textDocument: { uri },
+                                range: params.range,
+                                refactor: refactor.name,
+                                action: action.name,
+                            }
+                            actions.push({
+                                title: action.description,
+                                kind,
+                                command: {
+                                    title: action.description,
+                                    command: 'refactor',
+                                    arguments: [refactorArguments],
+                                },
+                            })
+                        }
+                    }
+                }
+
+                return actions
             })
-            .map(
-                (action: ts.CodeAction): Operation => ({
-                    op: 'add',
-                    path: '/-',
-                    value: {
-                        title: action.description,
-                        command: 'codeFix',
-                        arguments: action.changes,
-                    } as Command,
-                })
-            )
+            .map((action): Operation => ({ op: 'add', path: '/-', value: action }))
             .startWith({ op: 'add', path: '', value: [] } as Operation)
     }
 
@@ -1457,13 +1689,19 @@ export class TypeScriptService {
                     return Observable.throw(new Error(`Command ${params.command} requires arguments`))
                 }
                 return this.executeCodeFixCommand(params.arguments, span)
+            case 'refactor':
+                if (!params.arguments || params.arguments.length < 1) {
+                    return Observable.throw(new Error(`Command ${params.command} requires arguments`))
+                }
+                return this.executeRefactorCommand(params.arguments[0], span)
             default:
                 return Observable.throw(new Error(`Unknown command ${params.command}`))
         }
     }
 
     /**
-     * Executes the `codeFix` command
+     * Executes the `codeFix` command.
+     * Errors if the client responds that it did not apply the resulting workspace edit.
      *
      * @return Observable of JSON Patches for `null` result
      */
@@ -1495,20 +1733,75 @@ export class TypeScriptService {
                         changes[uri] = change.textChanges.map(
                             ({ span, newText }): TextEdit => ({
                                 range: {
-                                    start: ts.getLineAndCharacterOfPosition(sourceFile, span.start),
-                                    end: ts.getLineAndCharacterOfPosition(sourceFile, span.start + span.length),
+                                    start: offsetToPosition(sourceFile, span.start, this.positionEncoding),
+                                    end: offsetToPosition(sourceFile, span.start + span.length, this.positionEncoding),
                                 },
                                 newText,
                             })
                         )
                     }
 
-                    return this.client.workspaceApplyEdit({ edit: { changes } }, span)
+                    return this.client.workspaceApplyEdit({ edit: { changes } }, span).do(response => {
+                        if (!response.applied) {
+                            throw new Error('Client did not apply the workspace edit')
+                        }
+                    })
                 })
             )
             .map(() => ({ op: 'add', path: '', value: null } as Operation))
     }
 
+    /**
+     * Executes the `refactor` command by computing the edits of the refactoring and applying them like a code fix
+     *
+     * @return Observable of JSON Patches for `null` result
+     */
+    public executeRefactorCommand(
+        refactorArguments: RefactorCommandArguments,
+        span = new Span()
+    ): Observable<Operation> {
+        const uri = normalizeUri(refactorArguments.textDocument.uri)
+        return this.projectManager
+            .ensureReferencedFiles(uri, undefined, undefined, span)
+            .toArray()
+            .mergeMap(() => {
+                const configuration = this.projectManager.getParentConfiguration(uri)
+                if (!configuration) {
+                    throw new Error(`Could not find tsconfig for ${uri}`)
+                }
+                configuration.ensureBasicFiles(span)
+
+                const filePath = uri2path(uri)
+                const sourceFile = this._getSourceFile(configuration, filePath, span)
+                if (!sourceFile) {
+                    throw new Error(`Expected source file ${filePath} to exist in configuration`)
+                }
+
+                const start = positionToOffset(sourceFile, refactorArguments.range.start, this.positionEncoding)
+                const end = positionToOffset(sourceFile, refactorArguments.range.end, this.positionEncoding)
+
+                const editInfo = configuration
+                    .getService()
+                    .getEditsForRefactor(
+                        filePath,
+                        this.settings.format || {},
+                        { pos: start, end },
+                        refactorArguments.refactor,
+                        refactorArguments.action,
+                        undefined
+                    )
+                if (!editInfo || editInfo.edits.length === 0) {
+                    throw new Error(`Refactoring ${refactorArguments.refactor} is not applicable`)
+                }
+                if (editInfo.edits.some(edit => !!edit.isNewFile)) {
+                    throw new Error(
+                        `Refactoring ${refactorArguments.refactor} creates new files, which is not supported`
+                    )
+                }
+                return this.executeCodeFixCommand(editInfo.edits, span)
+            })
+    }
+
     /**
      * The rename request is sent from the client to the server to perform a workspace-wide rename of a symbol.
      *
@@ -1533,11 +1826,7 @@ export class TypeScriptService {
                         throw new Error(`Expected source file ${filePath} to exist in configuration`)
                     }
 
-                    const position = ts.getPositionOfLineAndCharacter(
-                        sourceFile,
-                        params.position.line,
-                        params.position.character
-                    )
+                    const position = positionToOffset(sourceFile, params.position, this.positionEncoding)
 
                     const renameInfo = configuration.getService().getRenameInfo(filePath, position)
                     if (!renameInfo.canRename) {
@@ -1553,10 +1842,11 @@ export class TypeScriptService {
                                 throw new Error(`expected source file ${location.fileName} to exist in configuration`)
                             }
                             const editUri = path2uri(location.fileName)
-                            const start = ts.getLineAndCharacterOfPosition(sourceFile, location.textSpan.start)
-                            const end = ts.getLineAndCharacterOfPosition(
+                            const start = offsetToPosition(sourceFile, location.textSpan.start, this.positionEncoding)
+                            const end = offsetToPosition(
                                 sourceFile,
-                                location.textSpan.start + location.textSpan.length
+                                location.textSpan.start + location.textSpan.length,
+                                this.positionEncoding
                             )
                             const edit: TextEdit = { range: { start, end }, newText: params.newName }
                             return [editUri, edit]
@@ -1578,14 +1868,165 @@ export class TypeScriptService {
             .startWith({ op: 'add', path: '', value: { changes: {} } as WorkspaceEdit } as Operation)
     }
 
+    /**
+     * The will rename files request is sent from the client to the server before files are actually
+     * renamed as long as the rename is triggered from within the client. The returned edit updates
+     * import paths and other references to the renamed files.
+     *
+     * @return Observable of JSON Patches that build a `WorkspaceEdit` result
+     */
+    public workspaceWillRenameFiles(params: RenameFilesParams, span = new Span()): Observable<Operation> {
+        const editUris = new Set<string>()
+        return this.projectManager
+            .ensureOwnFiles(span)
+            .concat(Observable.defer(() => observableFromIterable(params.files)))
+            .mergeMap(file => {
+                const oldUri = normalizeUri(file.oldUri)
+                const oldFilePath = uri2path(oldUri)
+                const newFilePath = uri2path(normalizeUri(file.newUri))
+                const configuration = this.projectManager.getParentConfiguration(oldUri)
+                if (!configuration) {
+                    throw new Error(`tsconfig.json not found for ${oldFilePath}`)
+                }
+                configuration.ensureAllFiles(span)
+
+                const fileTextChanges = configuration
+                    .getService()
+                    .getEditsForFileRename(oldFilePath, newFilePath, this.settings.format || {}, undefined)
+                return Observable.from(fileTextChanges).mergeMap(change => {
+                    const sourceFile = this._getSourceFile(configuration, change.fileName, span)
+                    if (!sourceFile) {
+                        throw new Error(`expected source file ${change.fileName} to exist in configuration`)
+                    }
+                    const editUri = path2uri(change.fileName)
+                    return change.textChanges.map(
+                        ({ span, newText }): [string, TextEdit] => [
+                            editUri,
+                            {
+                                range: {
+                                    start: offsetToPosition(sourceFile, span.start, this.positionEncoding),
+                                    end: offsetToPosition(sourceFile, span.start + span.length, this.positionEncoding),
+                                },
+                                newText,
+                            },
+                        ]
+                    )
+                })
+            })
+            .map(
+                ([uri, edit]): Operation => {
+                    // if file has no edit yet, initialize array
+                    if (!editUris.has(uri)) {
+                        editUris.add(uri)
+                        return { op: 'add', path: JSONPTR`/changes/${uri}`, value: [edit] }
+                    }
+                    // else append to array
+                    return { op: 'add', path: JSONPTR`/changes/${uri}/-`, value: edit }
+                }
+            )
+            .startWith({ op: 'add', path: '', value: { changes: {} } as WorkspaceEdit } as Operation)
+    }
+
+    /**
+     * Reports the progress of an already running operation to the client with `$/progress` notifications.
+     * Must only be called after the `initialize` response was sent, as it sends a window/workDoneProgress/create
+     * request. Subscribes to the operation only to observe its end, so the operation has to be shared and must
+     * not depend on the client creating the token.
+     *
+     * @param title The title of the progress, e.g. "Fetching workspace files"
+     * @param operation The operation to report progress for
+     */
+    protected _reportWorkDoneProgress(title: string, operation: Observable<any>, span = new Span()): void {
+        const token = ++this.lastWorkDoneProgressToken
+        this.client.windowWorkDoneProgressCreate({ token }, span).subscribe(
+            () => {
+                this.client.progress({ token, value: { kind: 'begin', title } })
+                operation.subscribe(
+                    undefined,
+                    err => {
+                        this.client.progress({ token, value: { kind: 'end', message: err.message } })
+                    },
+                    () => {
+                        this.client.progress({ token, value: { kind: 'end' } })
+                    }
+                )
+            },
+            err => {
+                this.logger.warn('Could not create work done progress token', err)
+            }
+        )
+    }
+
     /**
      * The initialized notification is sent from the client to the server after the client received
      * the result of the initialize request but before the client is sending any other request or
      * notification to the server. The server can use the initialized notification for example to
      * dynamically register capabilities.
+     *
+     * Reports the progress of the workspace pre-fetch started by `initialize` if the client supports server
+     * initiated progress. This can't happen earlier, as the server must not send requests before the
+     * `initialize` response.
+     * Registers file watchers for TypeScript, JavaScript and JSON files if the client supports it, so the
+     * server can pick up changes made outside of open documents (e.g. through git or other tools).
+     * Fetches the settings for the workspace if the client supports workspace/configuration, so clients
+     * don't have to push them with workspace/didChangeConfiguration.
      */
     public async initialized(): Promise<void> {
-        // nop
+        if (this.supportsWorkDoneProgress) {
+            this._reportWorkDoneProgress('Fetching workspace files', this.workspacePrefetch)
+        }
+        // Failures of one request must not prevent the others, so each is handled separately
+        if (this.supportsDidChangeWatchedFilesRegistration) {
+            try {
+                await this.client
+                    .clientRegisterCapability({
+                        registrations: [
+                            {
+                                id: 'workspace/didChangeWatchedFiles',
+                                method: 'workspace/didChangeWatchedFiles',
+                                registerOptions: {
+                                    watchers: [{ globPattern: '**/*.{ts,tsx,js,jsx,json}' }],
+                                },
+                            },
+                        ],
+                    })
+                    .toPromise()
+            } catch (err) {
+                this.logger.warn('Could not register file watchers', err)
+            }
+        }
+        if (this.supportsWorkspaceConfiguration) {
+            try {
+                const [settings] = await this.client
+                    .workspaceConfiguration({ items: [{ scopeUri: this.rootUri }] })
+                    .toPromise()
+                if (settings && typeof settings === 'object') {
+                    merge(this.settings, settings)
+                }
+            } catch (err) {
+                this.logger.warn('Could not fetch workspace configuration', err)
+            }
+        }
+    }
+
+    /**
+     * The watched files notification is sent from the client to the server when the client detects
+     * changes to files watched by the language client.
+     *
+     * Changes to documents opened by the client are ignored, as the client manages their content.
+     */
+    public async workspaceDidChangeWatchedFiles(params: DidChangeWatchedFilesParams): Promise<void> {
+        for (const change of params.changes) {
+            const uri = normalizeUri(change.uri)
+            if (this.documentVersions.has(uri)) {
+                continue
+            }
+            if (change.type === FileChangeType.Deleted) {
+                this.projectManager.didDeleteWatchedFile(uri)
+            } else {
+                await this.projectManager.didChangeWatchedFile(uri).toPromise()
+            }
+        }
     }
 
     /**
@@ -1595,9 +2036,14 @@ export class TypeScriptService {
      */
     public async textDocumentDidOpen(params: DidOpenTextDocumentParams): Promise<void> {
         const uri = normalizeUri(params.textDocument.uri)
+        // Record the opened content before waiting for anything,
+        // so that didChange notifications received in the meantime are applied on top of it
+        this.documentVersions.set(uri, params.textDocument.version)
+        this.inMemoryFileSystem.didChange(uri, params.textDocument.text)
         // Ensure files needed for most operations are fetched
         await this.projectManager.ensureReferencedFiles(uri).toPromise()
-        this.projectManager.didOpen(uri, params.textDocument.text)
+        // Use the current content, which includes changes received while fetching
+        this.projectManager.didOpen(uri, this.inMemoryFileSystem.getContent(uri))
         await new Promise<void>(resolve => setTimeout(resolve, 200))
         this._publishDiagnostics(uri)
     }
@@ -1606,19 +2052,32 @@ export class TypeScriptService {
      * The document change notification is sent from the client to the server to signal changes to a
      * text document. In 2.0 the shape of the params has changed to include proper version numbers
      * and language ids.
+     *
+     * Content changes are applied in order. Changes with a range are applied incrementally to the
+     * current content of the document, changes without a range replace the whole content.
      */
     public async textDocumentDidChange(params: DidChangeTextDocumentParams): Promise<void> {
         const uri = normalizeUri(params.textDocument.uri)
+        const version = params.textDocument.version
+        const knownVersion = this.documentVersions.get(uri)
+        if (typeof version === 'number' && knownVersion !== undefined && version <= knownVersion) {
+            this.logger.warn(`Ignoring textDocument/didChange for ${uri} version ${version}, already at ${knownVersion}`)
+            return
+        }
         let text: string | undefined
         for (const change of params.contentChanges) {
-            if (change.range || change.rangeLength) {
-                throw new Error('incremental updates in textDocument/didChange not supported for file ' + uri)
+            if (change.range && text === undefined) {
+                // Incremental changes are applied to the content the client has sent before
+                text = this.inMemoryFileSystem.getContent(uri)
             }
-            text = change.text
+            text = applyContentChange(text || '', change, this.positionEncoding)
         }
-        if (!text) {
+        if (text === undefined) {
             return
         }
+        if (typeof version === 'number') {
+            this.documentVersions.set(uri, version)
+        }
         this.projectManager.didChange(uri, text)
         await new Promise<void>(resolve => setTimeout(resolve, 200))
         this._publishDiagnostics(uri)
@@ -1645,7 +2104,9 @@ export class TypeScriptService {
                     .filter((e): e is ts.DiagnosticWithLocation => !!e.file)
             )
         const diagnostics = iterate(tsDiagnostics)
-            .map(convertTsDiagnostic)
+            .map(diagnostic =>
+                convertTsDiagnostic(diagnostic, this.positionEncoding, this.supportsDiagnosticRelatedInformation)
+            )
             .toArray()
         this.client.textDocumentPublishDiagnostics({ uri, diagnostics })
     }
@@ -1674,6 +2135,7 @@ export class TypeScriptService {
         await this.projectManager.ensureReferencedFiles(uri).toPromise()
 
         this.projectManager.didClose(uri)
+        this.documentVersions.delete(uri)
 
         // Clear diagnostics
         this.client.textDocumentPublishDiagnostics({ uri, diagnostics: [] })
@@ -1736,10 +2198,14 @@ export class TypeScriptService {
                         .filter(
                             item => !isTypeScriptLibrary(item.fileName) && !item.fileName.includes('/node_modules/')
                         )
-                        // Same score for all
+                        // Rank by how well the item matches, preferring case-sensitive matches
                         .map(
                             item =>
-                                [1, navigateToItemToSymbolInformation(item, program, this.root)] as [
+                                [
+                                    (NAVIGATE_TO_MATCH_KIND_SCORES.get(item.matchKind) || 1) +
+                                        (item.isCaseSensitive ? 0.5 : 0),
+                                    navigateToItemToSymbolInformation(item, program, this.root, this.positionEncoding),
+                                ] as [
                                     number,
                                     SymbolInformation
                                 ]
@@ -1817,7 +2283,13 @@ export class TypeScriptService {
                                     ({ score, tree, parent }) =>
                                         [
                                             score,
-                                            navigationTreeToSymbolInformation(tree, parent, sourceFile, this.root),
+                                            navigationTreeToSymbolInformation(
+                                                tree,
+                                                parent,
+                                                sourceFile,
+                                                this.root,
+                                                this.positionEncoding
+                                            ),
                                         ] as [number, SymbolInformation]
                                 )
                             } catch (e) {
@@ -3,7 +3,8 @@ import { Observable } from 'rxjs'
 import { compareTwoStrings } from 'string-similarity'
 import * as ts from 'typescript'
 import * as url from 'url'
-import { PackageDescriptor, SymbolDescriptor } from './request-type'
+import { Position, TextDocumentContentChangeEvent } from 'vscode-languageserver'
+import { PackageDescriptor, PositionEncodingKind, SymbolDescriptor } from './request-type'
 
 /**
  * Converts an Iterable to an Observable.
@@ -35,6 +36,15 @@ export function toUnixPath(filePath: string): string {
     return filePath.replace(/\\/g, '/')
 }
 
+/**
+ * Returns the host (and port) of a URI as written in the URI.
+ * In opposite to url.parse(), does not lowercase the host, which is case-sensitive for UNC paths.
+ */
+function getRawHost(uri: string): string {
+    const match = /^[a-z][a-z0-9+.-]*:\/\/([^\/?#]*)/i.exec(uri)
+    return match ? match[1].replace(/^.*@/, '') : ''
+}
+
 /**
  * Normalizes URI encoding by encoding _all_ special characters in the pathname
  */
@@ -43,6 +53,9 @@ export function normalizeUri(uri: string): string {
     if (!parts.pathname) {
         return uri
     }
+    if (parts.host) {
+        parts.host = getRawHost(uri)
+    }
     const pathParts = parts.pathname.split('/').map(segment => encodeURIComponent(decodeURIComponent(segment)))
     // Decode Windows drive letter colon
     if (/^[a-z]%3A$/i.test(pathParts[1])) {
@@ -63,6 +76,14 @@ export function path2uri(path: string): string {
         throw new Error(`${path} is not an absolute path`)
     }
 
+    // UNC paths like \\server\share\foo map to the URI authority, e.g. file://server/share/foo
+    // POSIX paths starting with // are not UNC paths
+    const unc = path.match(/^\\\\([^\\\/]+)(.*)$/)
+    if (unc) {
+        const [, host, rest] = unc
+        return `file://${host}${rest.split(/[\\\/]/).map(encodeURIComponent).join('/') || '/'}`
+    }
+
     const parts = path.split(/[\\\/]/)
 
     // If the first segment is a Windows drive letter, prefix with a slash and skip encoding
@@ -90,6 +111,11 @@ export function uri2path(uri: string): string {
 
     let filePath = parts.pathname || ''
 
+    // If the URI has an authority, it refers to a UNC path, e.g. file://server/share/foo -> \\server\share\foo
+    if (parts.host && parts.host !== 'localhost') {
+        return decodeURIComponent('\\\\' + getRawHost(uri) + filePath.replace(/\//g, '\\'))
+    }
+
     // If the path starts with a drive letter, return a Windows path
     if (/^\/[a-z]:\//i.test(filePath)) {
         filePath = filePath.substr(1).replace(/\//g, '\\')
@@ -98,6 +124,164 @@ export function uri2path(uri: string): string {
     return decodeURIComponent(filePath)
 }
 
+/**
+ * Returns the amount of code units the character(s) starting at the given UTF-16 index of a string take up in the given encoding,
+ * and the amount of UTF-16 code units they span (2 for surrogate pairs)
+ */
+function measureCharacter(text: string, index: number, encoding: PositionEncodingKind): [number, number] {
+    const code = text.charCodeAt(index)
+    // A surrogate pair encodes a single code point outside the Basic Multilingual Plane
+    if (code >= 0xd800 && code <= 0xdbff && index + 1 < text.length) {
+        const next = text.charCodeAt(index + 1)
+        if (next >= 0xdc00 && next <= 0xdfff) {
+            return [encoding === 'utf-8' ? 4 : encoding === 'utf-16' ? 2 : 1, 2]
+        }
+    }
+    if (encoding !== 'utf-8') {
+        return [1, 1]
+    }
+    return [code < 0x80 ? 1 : code < 0x800 ? 2 : 3, 1]
+}
+
+/**
+ * Converts a UTF-16 based character offset in a line to a column in the given encoding
+ *
+ * @param lineText The text of the line the offset refers to
+ * @param character The UTF-16 code unit offset in the line (as used by TypeScript and JavaScript strings)
+ * @param encoding The encoding to return the column in
+ */
+export function fromUtf16Character(lineText: string, character: number, encoding: PositionEncodingKind): number {
+    if (encoding === 'utf-16') {
+        return character
+    }
+    let column = 0
+    let index = 0
+    while (index < character && index < lineText.length) {
+        const [units, length] = measureCharacter(lineText, index, encoding)
+        column += units
+        index += length
+    }
+    // Offsets past the end of the line are kept as they are
+    return column + Math.max(0, character - lineText.length)
+}
+
+/**
+ * Converts a column in the given encoding to a UTF-16 based character offset in a line
+ *
+ * @param lineText The text of the line the column refers to
+ * @param column The column in the given encoding
+ * @param encoding The encoding the column is in
+ * @return The UTF-16 code unit offset in the line (as used by TypeScript and JavaScript strings)
+ */
+export function toUtf16Character(lineText: string, column: number, encoding: PositionEncodingKind): number {
+    if (encoding === 'utf-16') {
+        return column
+    }
+    let consumed = 0
+    let index = 0
+    while (index < lineText.length && consumed < column) {
+        const [units, length] = measureCharacter(lineText, index, encoding)
+        consumed += units
+        index += length
+    }
+    // Columns past the end of the line are kept as they are
+    return index + Math.max(0, column - consumed)
+}
+
+/**
+ * Returns the text of a line in a source file, without the line break
+ */
+function getLineText(sourceFile: ts.SourceFile, line: number): string {
+    const lineStarts = sourceFile.getLineStarts()
+    const end = line + 1 < lineStarts.length ? lineStarts[line + 1] : sourceFile.text.length
+    return sourceFile.text.slice(lineStarts[line], end).replace(/\r?\n$/, '')
+}
+
+/**
+ * Converts an offset in a source file to an LSP Position
+ *
+ * @param encoding The encoding the character of the Position is counted in
+ */
+export function offsetToPosition(
+    sourceFile: ts.SourceFile,
+    offset: number,
+    encoding: PositionEncodingKind = 'utf-16'
+): Position {
+    const { line, character } = ts.getLineAndCharacterOfPosition(sourceFile, offset)
+    if (encoding === 'utf-16') {
+        return { line, character }
+    }
+    return { line, character: fromUtf16Character(getLineText(sourceFile, line), character, encoding) }
+}
+
+/**
+ * Converts an LSP Position to an offset in a source file
+ *
+ * @param encoding The encoding the character of the Position is counted in
+ */
+export function positionToOffset(
+    sourceFile: ts.SourceFile,
+    position: Position,
+    encoding: PositionEncodingKind = 'utf-16'
+): number {
+    let character = position.character
+    if (encoding !== 'utf-16') {
+        character = toUtf16Character(getLineText(sourceFile, position.line), character, encoding)
+    }
+    return ts.getPositionOfLineAndCharacter(sourceFile, position.line, character)
+}
+
+/**
+ * Converts an LSP Position to an offset in a plain text that has no SourceFile (yet).
+ * Positions past the end of a line or the text are clamped.
+ *
+ * @param encoding The encoding the character of the Position is counted in
+ */
+function positionToTextOffset(text: string, position: Position, encoding: PositionEncodingKind): number {
+    let lineStart = 0
+    for (let line = 0; line < position.line; line++) {
+        const lineBreak = text.indexOf('\n', lineStart)
+        if (lineBreak === -1) {
+            return text.length
+        }
+        lineStart = lineBreak + 1
+    }
+    let lineEnd = text.indexOf('\n', lineStart)
+    if (lineEnd === -1) {
+        lineEnd = text.length
+    }
+    const lineText = text.slice(lineStart, lineEnd).replace(/\r$/, '')
+    return lineStart + Math.min(toUtf16Character(lineText, position.character, encoding), lineText.length)
+}
+
+/**
+ * Applies a content change of a textDocument/didChange notification to the text of a document.
+ * A change without a range replaces the whole text.
+ *
+ * @param encoding The encoding the characters of the change range are counted in
+ * @return The new text of the document
+ */
+export function applyContentChange(
+    text: string,
+    change: TextDocumentContentChangeEvent,
+    encoding: PositionEncodingKind = 'utf-16'
+): string {
+    if (!change.range) {
+        return change.text
+    }
+    const start = positionToTextOffset(text, change.range.start, encoding)
+    const end = positionToTextOffset(text, change.range.end, encoding)
+    return text.slice(0, start) + change.text + text.slice(end)
+}
+
+/**
+ * Escapes text to be inserted literally by a completion item with InsertTextFormat.Snippet,
+ * e.g. identifiers like `$` or `$scope` that would otherwise be interpreted as tabstops or variables
+ */
+export function escapeSnippetText(text: string): string {
+    return text.replace(/[$}\\]/g, '\\$&')
+}
+
 const jstsPattern = /\.[tj]sx?$/
 
 export function isJSTSFile(filename: string): boolean {